@@ -1,5 +1,8 @@
 package mux
 
+// StaticFiles のデフォルト設定で、テンプレートとして処理する最大のファイルサイズ
+const maxAssetSize = 5 << 20
+
 // AssetsTemplate : 静的ファイルを取り扱う構造体
 type AssetsTemplate struct {
 	path    string // クエリパス (ex: /assets/stylesheets/controllers/index.css)
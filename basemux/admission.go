@@ -0,0 +1,126 @@
+package basemux
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Limit : パス毎に最大リクエスト同時接続数を制限する構造体
+type Limit struct {
+	Path       string        // 制限対象のパス(前方一致)
+	MaxClients int           // 最大リクエスト同時接続数
+	sem        chan struct{} // リクエスト受付管理チャネル
+}
+
+// リクエスト受付管理チャネルを生成する
+func (limit *Limit) init() error {
+	// 最大リクエスト同時接続数が未設定の場合はエラーとする
+	if limit.MaxClients <= 0 {
+		return fmt.Errorf("'%s' limit MaxClients must be greater than 0", limit.Path)
+	}
+	// /path/to/url => /path/to/url/ へ変換する
+	limit.Path = strings.TrimRight(limit.Path, "/") + "/"
+
+	limit.sem = make(chan struct{}, limit.MaxClients)
+	for i := 0; i < limit.MaxClients; i++ {
+		limit.sem <- struct{}{}
+	}
+	return nil
+}
+
+// 指定されたパスに該当する制限のうち、最も長いパスの制限を返却する
+func (mux *Mux) limit(path string) *Limit {
+	path = strings.TrimRight(path, "/") + "/"
+
+	var result *Limit
+	for _, v := range mux.Limits {
+		if strings.Index(path, v.Path) != 0 {
+			continue
+		}
+		if result == nil || len(result.Path) < len(v.Path) {
+			result = v
+		}
+	}
+	return result
+}
+
+// 空き待ちのリクエスト数を1つ増やす。空き待ちの最大数に到達している場合は false を返却する
+func (mux *Mux) enqueue() bool {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	if mux.MaxQueue > 0 && mux.waiting >= mux.MaxQueue {
+		return false
+	}
+	mux.waiting++
+	return true
+}
+
+// 空き待ちのリクエスト数を1つ減らす
+func (mux *Mux) dequeue() {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	mux.waiting--
+}
+
+// リクエストの受付可否を判定し、受付可能になるまで待つ。受付後は、復帰値の関数をコールして返却する
+func (mux *Mux) admit(r *http.Request) (func(), error) {
+	// パス毎の制限を先に確保することで、特定のパスへの集中が他のリクエストの受付を妨げないようにする
+	var sems []chan struct{}
+	if limit := mux.limit(r.URL.Path); limit != nil {
+		sems = append(sems, limit.sem)
+	}
+	sems = append(sems, mux.sem)
+
+	// 確保済みの受付管理チャネルをすべて返却する関数
+	var acquired []chan struct{}
+	release := func() {
+		for _, sem := range acquired {
+			sem <- struct{}{}
+		}
+	}
+
+	var timer *time.Timer
+	for _, sem := range sems {
+		// 空きがある場合は、待たずに受け付ける
+		select {
+		case <-sem:
+			acquired = append(acquired, sem)
+			continue
+		default:
+		}
+
+		// 空きがない場合、空き待ちの最大数を超過していれば 503 エラーとする
+		if timer == nil {
+			if mux.enqueue() == false {
+				release()
+				return nil, maxClientsError(mux.RetryAfter)
+			}
+			defer mux.dequeue()
+			timer = time.NewTimer(time.Duration(mux.QueueTimeout) * time.Second)
+			defer timer.Stop()
+		}
+
+		select {
+		// 空きができた場合、リクエストを受け付ける
+		case <-sem:
+			acquired = append(acquired, sem)
+		// 空き待ちの最大時間を超過した場合、503 エラーとする
+		case <-timer.C:
+			release()
+			return nil, maxClientsError(mux.RetryAfter)
+		// 待機中にシャットダウンされた場合は、リクエストを処理しない
+		case <-mux.done:
+			release()
+			return nil, shutdownError()
+		// 待機中にクライアントが切断した場合は、リクエストを処理しない
+		case <-r.Context().Done():
+			release()
+			return nil, r.Context().Err()
+		}
+	}
+
+	return release, nil
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,12 +16,39 @@ type muxHandler struct {
 }
 
 func (muxHandler *muxHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// レスポンス圧縮が有効な場合、Accept-Encoding に応じて圧縮する ResponseWriter を使用する
+	var cw *compressWriter
+	if c := muxHandler.mux.Compress; c != nil && c.Disable == false {
+		cw = newCompressWriter(w, r, c)
+		w = cw
+	}
 	// 独自ResponseWriterを作成
 	response := &ResponseWriter{
-		w, muxHandler.mux.referer.Create(),
+		w, muxHandler.mux.Store.Create(),
+	}
+	// 署名鍵が設定されている場合、リンクIDをクライアントに紐付ける
+	if key := muxHandler.mux.LinkKey; key != nil {
+		values := response.Values
+		values.bind(key, func() string {
+			return muxHandler.mux.LinkOwner(r, values)
+		})
 	}
 	// リクエストを処理
 	muxHandler.mux.active(response, r)
+	// 圧縮処理を終了し、残りの内容を書き込む
+	if cw != nil {
+		if err := cw.close(); err != nil {
+			log.Println(err)
+		}
+	}
+	// リクエスト処理後、値がセットされた Values のみ保存する
+	// 静的ファイルや、受け付けなかったリクエストなど、値をセットしていない Values は保存しない
+	if response.Values.changed() == false {
+		return
+	}
+	if err := muxHandler.mux.Store.Touch(response.Values); err != nil {
+		log.Println(err)
+	}
 }
 
 // View : Main関数の処理後、エラーがなければMainの復帰値として返却される
@@ -56,13 +85,26 @@ type Handler interface {
 
 // Mux : 受け付けるリクエストの最大数を管理する構造体
 type Mux struct {
-	MaxClients int           // 最大リクエスト同時接続数
-	Timeout    int           // タイムアウト時間(秒単位)
-	MaxMemory  int64         // アップロードファイルを処理する際に使用する最大メモリ量(MB単位)
-	MethodName string        // オリジナルメソッドキー名
-	Handler    Handler       // リクエストを処理するハンドラ
-	sem        chan struct{} // リクエスト受付管理チャネル
-	referer    *referer      // 1つ前のページ情報を保持している独自リファラ
+	MaxClients    int             // 最大リクエスト同時接続数
+	MaxQueue      int             // 空き待ちできる最大リクエスト数(0の場合は無制限)
+	QueueTimeout  int             // 空き待ちの最大時間(秒単位)
+	RetryAfter    int             // 最大リクエスト同時接続数超過時に、Retry-Afterヘッダへ設定する秒数
+	Limits        []*Limit        // パス毎の最大リクエスト同時接続数
+	Timeout       int             // タイムアウト時間(秒単位)
+	RouteTimeouts []*RouteTimeout // パス毎のタイムアウト時間
+	MaxMemory     int64           // アップロードファイルを処理する際に使用する最大メモリ量(MB単位)
+	MethodName    string          // オリジナルメソッドキー名
+	Handler       Handler         // リクエストを処理するハンドラ
+	Store         SessionStore    // Values を保存するストア(未設定の場合はメモリ上に保存する)
+	LinkKey       []byte          // リンクIDの署名鍵(16バイト以上)。設定した場合、リンクIDをクライアントに紐付ける
+	LinkOwner     LinkOwner       // リンクIDを紐付けるクライアントの識別子を返却する関数(未設定の場合はクライアントのIPアドレス)
+	Compress      *Compress       // レスポンス圧縮の設定(未設定の場合は圧縮しない)
+	sem           chan struct{}   // リクエスト受付管理チャネル
+	mu            sync.Mutex      // closed, wg, waiting を保護するミューテックス
+	wg            sync.WaitGroup  // 処理中のリクエストを管理するウェイトグループ
+	done          chan struct{}   // シャットダウン通知チャネル
+	closed        bool            // シャットダウン済みか否か
+	waiting       int             // 空き待ちのリクエスト数
 }
 
 // GenerateHandler : 設定したMux構造体のパラメータから、ハンドラを作成する
@@ -79,10 +121,30 @@ func (mux *Mux) GenerateHandler() (http.Handler, error) {
 	for i := 0; i < mux.MaxClients; i++ {
 		mux.sem <- struct{}{}
 	}
+	// パス毎のリクエスト受付管理チャネルを設定する
+	for _, limit := range mux.Limits {
+		if err := limit.init(); err != nil {
+			return nil, err
+		}
+	}
 	// タイムアウト時間が未設定の場合、60秒をデフォルトの時間とする
 	if mux.Timeout <= 0 {
 		mux.Timeout = 60
 	}
+	// パス毎のタイムアウト時間を設定する
+	for _, rt := range mux.RouteTimeouts {
+		if err := rt.init(); err != nil {
+			return nil, err
+		}
+	}
+	// 空き待ちの最大時間が未設定の場合、タイムアウト時間と同じとする
+	if mux.QueueTimeout <= 0 {
+		mux.QueueTimeout = mux.Timeout
+	}
+	// Retry-Afterヘッダの秒数が未設定の場合、空き待ちの最大時間と同じとする
+	if mux.RetryAfter <= 0 {
+		mux.RetryAfter = mux.QueueTimeout
+	}
 	// アップロードファイルを処理する際に使用する最大メモリ量を設定(MB単位)
 	if mux.MaxMemory <= 0 {
 		mux.MaxMemory = 32
@@ -92,12 +154,109 @@ func (mux *Mux) GenerateHandler() (http.Handler, error) {
 	if mux.MethodName == "" {
 		mux.MethodName = "_method"
 	}
-	// リファラ管理構造体を作成する
-	mux.referer = newRefer(60)
+	// リンクIDの署名鍵が短すぎる場合はエラーとする
+	if mux.LinkKey != nil && len(mux.LinkKey) < 16 {
+		return nil, fmt.Errorf("LinkKey must be at least 16 bytes")
+	}
+	// クライアントの識別子を返却する関数が未設定の場合、クライアントのIPアドレスを識別子とする
+	if mux.LinkOwner == nil {
+		mux.LinkOwner = RemoteAddr
+	}
+	// レスポンス圧縮の設定を検証する
+	if mux.Compress != nil && mux.Compress.Disable == false {
+		if err := mux.Compress.init(); err != nil {
+			return nil, err
+		}
+	}
+	// シャットダウン通知チャネルを作成する
+	mux.done = make(chan struct{})
+	mux.closed = false
+	// ストアが未設定の場合、リファラ管理構造体を作成する
+	if mux.Store == nil {
+		mux.Store = newRefer(60)
+	}
+	// リファラ以外のストアの場合、期限切れチェックを行う
+	if _, ok := mux.Store.(*referer); !ok {
+		go mux.expire(60 * time.Second)
+	}
 
 	return &muxHandler{mux}, nil
 }
 
+// Shutdown : 新規リクエストの受付を停止し、処理中のリクエストが完了するか、ctx が終了するまで待つ
+func (mux *Mux) Shutdown(ctx context.Context) error {
+	if err := mux.Close(); err != nil {
+		return err
+	}
+
+	// 処理中のリクエストがすべて完了するまで待つ
+	finish := make(chan struct{})
+	go func() {
+		mux.wg.Wait()
+		close(finish)
+	}()
+
+	select {
+	// 処理中のリクエストがすべて完了した
+	case <-finish:
+		return nil
+	// 処理中のリクエストの完了前に、ctx が終了した
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close : 新規リクエストの受付と、リファラの期限切れチェックを停止する。処理中のリクエストの完了は待たない
+func (mux *Mux) Close() error {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	// ハンドラ未生成の場合はエラーとする
+	if mux.done == nil {
+		return fmt.Errorf("handler is not generated")
+	}
+	// 既にシャットダウン済みの場合は何もしない
+	if mux.closed {
+		return nil
+	}
+	mux.closed = true
+	close(mux.done)
+	if r, ok := mux.Store.(*referer); ok {
+		r.Close()
+	}
+
+	return nil
+}
+
+// latency 毎に、ストアに保存されたデータの期限切れをチェックして削除する
+func (mux *Mux) expire(latency time.Duration) {
+	t := time.NewTicker(latency)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := mux.Store.Expire(latency * 2); err != nil {
+				log.Println(err)
+			}
+		// シャットダウンされた場合、期限切れチェックを終了する
+		case <-mux.done:
+			return
+		}
+	}
+}
+
+// シャットダウン中でなければ、処理中のリクエストとして登録する
+func (mux *Mux) acquire() bool {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	if mux.closed {
+		return false
+	}
+	mux.wg.Add(1)
+	return true
+}
+
 // リクエストを処理する
 func (mux *Mux) active(w http.ResponseWriter, r *http.Request) {
 	// 使用するプロトコルを選定
@@ -115,16 +274,14 @@ func (mux *Mux) active(w http.ResponseWriter, r *http.Request) {
 		r.URL.Path = "/"
 	}
 
-	// ステータスコードを管理する変数
-	var status int
-	// リクエストタイムアウトを検知するコンテキストを生成
-	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(mux.Timeout)*time.Second)
-	// タイムアウトせずに、リクエストを処理したか検知するフラグ
-	isfinish := make(chan interface{}, 1)
+	// シャットダウン中の場合、リクエストを受け付けずに 503 エラーとする
+	if mux.acquire() == false {
+		mux.Handler.Error(shutdownError(), w, r)
+		return
+	}
 
+	// panic 発生時は recover を実施する
 	defer func() {
-		cancel()
-		// panic 発生時は recover を実施する
 		if err := recover(); err != nil {
 			status := PanicDump(0, err)
 			if p, ok := status.(*PanicError); ok {
@@ -134,35 +291,70 @@ func (mux *Mux) active(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
-		mux.sem <- struct{}{}
 	}()
 
-	go func() {
-		select {
-		// 最大リクエスト同時接続数に到達していない場合、リクエストを受け付ける
-		case <-mux.sem:
-			status = 408
-			mux.main(isfinish, w, r)
-		// 最大リクエスト同時接続数を超過している場合、処理を待つ
-		default:
-			status = 503
-			<-mux.sem
-			mux.main(isfinish, w, r)
+	// リクエストの受付可否を判定し、受付可能になるまで待つ
+	release, err := mux.admit(r)
+	if err != nil {
+		mux.wg.Done()
+		switch types := err.(type) {
+		// 最大リクエスト同時接続数を超過した場合は、再試行までの秒数を通知する
+		case *MaxClientsError:
+			w.Header().Set("Retry-After", strconv.Itoa(types.RetryAfter))
+			mux.Handler.Error(err, w, r)
+		case *ShutdownError:
+			mux.Handler.Error(err, w, r)
 		}
+		// 空き待ち中にクライアントが切断した場合は、何もしない
+		return
+	}
+
+	// タイムアウト後のアクションからの書き込みを破棄するため、Main へは専用の ResponseWriter を渡す
+	tw := newTimeoutWriter(w)
+	response := &ResponseWriter{tw, w.(*ResponseWriter).Values}
+	// リクエストタイムアウトを検知するコンテキストを生成し、Main へ渡すリクエストに紐付ける
+	// タイムアウト時間は、Main の処理中に SetTimeout で変更できる
+	ctx := newTimeoutContext(r.Context(), mux.timeout(r.URL.Path), tw.timeout, mux.done)
+	defer ctx.stop()
+	r = r.WithContext(ctx)
+	// タイムアウトせずに、リクエストを処理したか検知するフラグ
+	isfinish := make(chan interface{}, 1)
+
+	go func() {
+		// タイムアウト後も、アクションの処理が完了するまでは処理中のリクエストとして扱う
+		defer mux.wg.Done()
+		// 処理完了後、リクエスト受付管理チャネルへ返却する
+		defer release()
+		mux.main(isfinish, response, r)
 	}()
 
 	// リクエストタイムアウトを検知
 	select {
-	// タイムアウトの場合、408 or 503 エラーとする
+	// タイムアウトの場合、408 エラーとする
 	case <-ctx.Done():
-		if ctx.Err() == context.DeadlineExceeded {
-			mux.Handler.Error(accessError(status), w, r)
+		// 以降、アクションからの書き込みと描画結果は破棄する
+		tw.timeout()
+		if ctx.Err() != context.DeadlineExceeded {
+			break
+		}
+		// 書き込み途中でタイムアウトした場合は、エラー画面を出力できないため、ログへ出力する
+		if tw.written() {
+			log.Println(timeoutError(ctx.limit()).Error() + ": " + r.URL.Path)
+		} else {
+			mux.Handler.Error(timeoutError(ctx.limit()), w, r)
 		}
 	// タイムアウトせずにリクエストを処理
 	case v := <-isfinish:
+		// アクションで設定したヘッダを反映する
+		tw.finish()
 		switch types := v.(type) {
 		// リクエスト処理中にエラー発生
 		case error:
+			// 書き込み途中でエラーが発生した場合は、エラー画面を出力できないため、ログへ出力する
+			if tw.written() {
+				log.Println(types.Error() + ": " + r.URL.Path)
+				break
+			}
 			mux.Handler.Error(types, w, r)
 		// 正常にリクエストを処理
 		case Render:
@@ -198,13 +390,19 @@ func (mux *Mux) main(isfinish chan interface{}, w http.ResponseWriter, r *http.R
 			}
 		}
 		r.ParseForm()
-		// GET/POST以外のリクエストメソッドを指定している場合、r.Methodに指定されたメソッド名を格納する
-		if len(r.PostForm[mux.MethodName]) != 0 && r.PostForm[mux.MethodName][0] != "" {
+		// POST で GET/POST以外のリクエストメソッドを指定している場合、r.Methodに指定されたメソッド名を格納する
+		if strings.ToUpper(r.Method) == "POST" && len(r.PostForm[mux.MethodName]) != 0 && r.PostForm[mux.MethodName][0] != "" {
 			r.Method = r.PostForm[mux.MethodName][0]
 		}
 	}
 
 	v := w.(*ResponseWriter)
 	// メイン処理実行
-	render, err = mux.Handler.Main(w, r, mux.referer, v.Values)
+	render, err = mux.Handler.Main(w, r, mux.Store, v.Values)
+
+	// Streamer の場合は、タイムアウト時間内で描画する
+	if stream, ok := render.(Streamer); ok && err == nil {
+		render = nil
+		err = stream.Stream(w, r)
+	}
 }
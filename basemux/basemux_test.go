@@ -1,14 +1,20 @@
 package basemux
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -30,17 +36,37 @@ func (h *TestHandler) Error(err error, w http.ResponseWriter, r *http.Request) {
 	case *PanicType:
 		panic("PANIC")
 	case *TimeoutError:
+		w.Header().Set("X-Timeout", fmt.Sprint(t.Timeout))
 		w.WriteHeader(t.StatusCode)
 	case *PanicError:
 		w.WriteHeader(t.StatusCode)
 	case *MaxClientsError:
 		w.WriteHeader(t.StatusCode)
+	case *ShutdownError:
+		w.WriteHeader(t.StatusCode)
 	default:
 		w.WriteHeader(500)
 	}
 	w.Write([]byte(err.Error()))
 }
 
+// 接続を乗っ取り、レスポンスを直接書き込む Streamer
+type hijackView struct{}
+
+func (v *hijackView) Render(w http.ResponseWriter, r *http.Request) {}
+
+func (v *hijackView) Stream(w http.ResponseWriter, r *http.Request) error {
+	DisableTimeout(r)
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	time.Sleep(1500 * time.Millisecond)
+	rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 6\r\nConnection: close\r\n\r\nHIJACK")
+	return rw.Flush()
+}
+
 // Main : リクエストを処理するエントリポイント
 func (h *TestHandler) Main(w http.ResponseWriter, r *http.Request, refer Referer, v *Values) (Render, error) {
 	switch r.URL.Path {
@@ -84,7 +110,7 @@ func (h *TestHandler) Main(w http.ResponseWriter, r *http.Request, refer Referer
 			panic("/value")
 		}
 		// 過去に登録されていたリファラIDを取得する
-		if v.Old(v.ID()) == nil {
+		if v.Old(v.LinkID()) == nil {
 			panic("/value")
 		}
 		// 存在しないIDが指定された場合は、エラーとする
@@ -104,6 +130,156 @@ func (h *TestHandler) Main(w http.ResponseWriter, r *http.Request, refer Referer
 			ContentType: "text/html",
 			StatusCode:  200,
 		}, nil
+	case "/sleep", "/sleep/limit":
+		time.Sleep(2 * time.Second)
+		return &View{
+			Buffer:      []byte("SLEEP"),
+			ContentType: "text/html",
+			StatusCode:  200,
+		}, nil
+	// 処理中のタイムアウト時間変更チェック
+	case "/settimeout":
+		if SetTimeout(r, 0) {
+			panic("/settimeout")
+		}
+		if SetTimeout(r, 1) == false {
+			panic("/settimeout")
+		}
+		time.Sleep(2 * time.Second)
+		return &View{
+			Buffer:      []byte("SETTIMEOUT"),
+			ContentType: "text/html",
+			StatusCode:  200,
+		}, nil
+	// タイムアウト後の書き込みチェック
+	case "/cancel":
+		// タイムアウトすると、リクエストのコンテキストに通知される
+		<-r.Context().Done()
+		// タイムアウト後の書き込みは破棄される
+		w.Header().Set("X-Late", "late")
+		if _, err := w.Write([]byte("LATE")); err != http.ErrHandlerTimeout {
+			panic("/cancel")
+		}
+		return &View{
+			Buffer:      []byte("LATE"),
+			ContentType: "text/html",
+			StatusCode:  200,
+		}, nil
+	// アクションで設定したヘッダの反映チェック
+	case "/header":
+		w.Header().Set("X-Header", "header")
+		return &View{
+			Buffer:      []byte("HEADER"),
+			ContentType: "text/html",
+			StatusCode:  200,
+		}, nil
+	// リンクIDを返却する
+	case "/link":
+		// 値をセットした Values のみ保存される
+		v.Set("key", "value")
+		return &View{
+			Buffer:      []byte(v.LinkID()),
+			ContentType: "text/html",
+			StatusCode:  200,
+		}, nil
+	// 指定されたリンクIDで、過去に登録されていた情報を取得する
+	case "/link/old":
+		var result = "NOTFOUND"
+		if v.Old(r.URL.Query().Get("id")) != nil {
+			result = "FOUND"
+		}
+		return &View{
+			Buffer:      []byte(result),
+			ContentType: "text/html",
+			StatusCode:  200,
+		}, nil
+	// レスポンスボディを逐次書き込む
+	case "/stream":
+		return &StreamView{
+			ContentType:   "text/csv",
+			ContentLength: 12,
+			Header:        http.Header{"Content-Disposition": {"attachment; filename=test.csv"}},
+			Body: func(w io.Writer) error {
+				for i := 0; i < 3; i++ {
+					fmt.Fprintf(w, "%d,%d\n", i, i)
+				}
+				return nil
+			},
+		}, nil
+	// 書き込み前にエラーが発生した場合は、エラー画面を出力する
+	case "/stream/error":
+		return &StreamView{
+			Body: func(w io.Writer) error {
+				return fmt.Errorf("STREAM ERROR")
+			},
+		}, nil
+	// 書き込み開始後は、タイムアウト時間を超えても書き込める
+	case "/stream/timeout":
+		return &StreamView{
+			ContentType: "text/plain",
+			Body: func(w io.Writer) error {
+				w.Write([]byte("BEGIN"))
+				time.Sleep(1500 * time.Millisecond)
+				if _, err := w.Write([]byte("END")); err != nil {
+					panic("/stream/timeout")
+				}
+				return nil
+			},
+		}, nil
+	// 書き込み前にタイムアウトした場合は、それ以降の書き込みを破棄する
+	case "/stream/wait":
+		return &StreamView{
+			ContentType: "text/plain",
+			Body: func(w io.Writer) error {
+				<-r.Context().Done()
+				if _, err := w.Write([]byte("END")); err != http.ErrHandlerTimeout {
+					panic("/stream/wait")
+				}
+				return nil
+			},
+		}, nil
+	// Server-Sent Events の送信
+	case "/events":
+		return &EventStreamView{
+			Retry: 3000,
+			Body: func(ew *EventWriter) error {
+				// 再接続時は、最後に受信したイベントIDの次から送信する
+				var id int
+				fmt.Sscan(ew.LastEventID(), &id)
+				for {
+					id++
+					if err := ew.Send(&Event{ID: fmt.Sprint(id), Name: "progress", Data: "line1\nline2"}); err != nil {
+						return err
+					}
+					select {
+					case <-ew.Context().Done():
+						return nil
+					case <-time.After(400 * time.Millisecond):
+					}
+				}
+			},
+		}, nil
+	// 最小サイズ以上のレスポンスは圧縮する
+	case "/compress", "/compress/image":
+		var content = "text/html; charset=UTF-8"
+		if r.URL.Path == "/compress/image" {
+			content = "image/png"
+		}
+		return &View{
+			Buffer:      bytes.Repeat([]byte("COMPRESS"), 256),
+			ContentType: content,
+			StatusCode:  200,
+		}, nil
+	// ディスク上のファイルの送信
+	case "/file":
+		return &FileView{
+			Name:         "testdata/range.txt",
+			ContentType:  "text/plain",
+			CacheControl: "no-cache",
+		}, nil
+	// 接続の乗っ取り
+	case "/hijack":
+		return &hijackView{}, nil
 	case "/upload":
 		return &View{
 			Buffer:      []byte("UPLOAD"),
@@ -464,3 +640,887 @@ func Test_BasemuxErrorPanic(t *testing.T) {
 	}
 	defer res.Body.Close()
 }
+
+// シャットダウンチェック
+func Test_Shutdown(t *testing.T) {
+	// Mux を設定
+	mux := &Mux{
+		MaxClients: 2,              // 同時受付リクエスト数(2リクエストまで)
+		Timeout:    10,             // リクエストタイムアウト(10秒間)
+		MaxMemory:  32,             // アップロードファイルの処理に使用する最大使用メモリ量(32MB)
+		Handler:    &TestHandler{}, // ハンドラを登録
+	}
+
+	// ハンドラ生成前のシャットダウンはエラーとする
+	if err := mux.Shutdown(context.Background()); err == nil {
+		t.Fatal("ERROR")
+	}
+
+	// ハンドラを生成
+	handler, err := mux.GenerateHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// http サーバを立てる
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	// 処理に時間のかかるリクエストを投入
+	client := ts.Client()
+	finish := make(chan string, 1)
+	go func() {
+		res, err := client.Get(ts.URL + "/timeout")
+		if err != nil {
+			finish <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		var buf = make([]byte, res.ContentLength)
+		res.Body.Read(buf)
+		finish <- string(buf)
+	}()
+	time.Sleep(1 * time.Second)
+
+	// 処理中のリクエストが完了する前に ctx が終了した場合は、エラーとする
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if err := mux.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+
+	// シャットダウン中の新規リクエストは 503 エラーとする
+	res, err := client.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 503 {
+		t.Fatal(res.StatusCode)
+	}
+
+	// 処理中のリクエストが完了するまで待つ
+	if err := mux.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-finish; v != "TIMEOUT" {
+		t.Fatal(v)
+	}
+}
+
+// タイムアウト時のキャンセルチェック
+func Test_Cancel(t *testing.T) {
+	// Mux を設定
+	mux := &Mux{
+		MaxClients: 2,              // 同時受付リクエスト数(2リクエストまで)
+		Timeout:    1,              // リクエストタイムアウト(1秒間)
+		MaxMemory:  32,             // アップロードファイルの処理に使用する最大使用メモリ量(32MB)
+		Handler:    &TestHandler{}, // ハンドラを登録
+	}
+
+	// ハンドラを生成
+	handler, err := mux.GenerateHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mux.Close()
+
+	// http サーバを立てる
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	// タイムアウト後の書き込み、描画結果は破棄される
+	client := ts.Client()
+	res, err := client.Get(ts.URL + "/cancel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var buf = make([]byte, res.ContentLength)
+	res.Body.Read(buf)
+	if string(buf) != "request time-out" || res.StatusCode != 408 || res.Header.Get("X-Late") != "" {
+		t.Fatal(string(buf))
+	}
+
+	// タイムアウト前であれば、アクションで設定したヘッダは反映される
+	res, err = client.Get(ts.URL + "/header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("X-Header") != "header" {
+		t.Fatal("ERROR")
+	}
+
+	// アクションの処理が完了するまで待つ
+	if err := mux.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 空き待ちの最大数、最大時間チェック
+func Test_Queue(t *testing.T) {
+	// Mux を設定
+	mux := &Mux{
+		MaxClients:   1,              // 同時受付リクエスト数(1リクエストまで)
+		MaxQueue:     1,              // 空き待ちできるリクエスト数(1リクエストまで)
+		QueueTimeout: 1,              // 空き待ちの最大時間(1秒間)
+		RetryAfter:   3,              // 再試行までの秒数(3秒)
+		Timeout:      10,             // リクエストタイムアウト(10秒間)
+		MaxMemory:    32,             // アップロードファイルの処理に使用する最大使用メモリ量(32MB)
+		Handler:      &TestHandler{}, // ハンドラを登録
+	}
+
+	// ハンドラを生成
+	handler, err := mux.GenerateHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mux.Close()
+
+	// http サーバを立てる
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	// 処理に時間のかかるリクエストで、同時受付リクエスト数を埋める
+	client := ts.Client()
+	go client.Get(ts.URL + "/sleep")
+	time.Sleep(500 * time.Millisecond)
+
+	// 空き待ちのリクエストは、空き待ちの最大時間を超過すると 503 エラーとなる
+	queued := make(chan *http.Response, 1)
+	go func() {
+		res, err := client.Get(ts.URL + "/")
+		if err != nil {
+			t.Error(err)
+		}
+		queued <- res
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// 空き待ちの最大数を超過したリクエストは、待たずに 503 エラーとなる
+	start := time.Now()
+	res, err := client.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 503 || res.Header.Get("Retry-After") != "3" || time.Since(start) > 500*time.Millisecond {
+		t.Fatal(res.StatusCode, res.Header.Get("Retry-After"))
+	}
+
+	res = <-queued
+	res.Body.Close()
+	if res.StatusCode != 503 || res.Header.Get("Retry-After") != "3" {
+		t.Fatal(res.StatusCode, res.Header.Get("Retry-After"))
+	}
+
+	// 空きができると、リクエストを受け付ける
+	time.Sleep(2 * time.Second)
+	res, err = client.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 {
+		t.Fatal(res.StatusCode)
+	}
+}
+
+// パス毎の最大同時接続数チェック
+func Test_Limits(t *testing.T) {
+	// 最大同時接続数が未設定の場合はエラーとする
+	mux := &Mux{
+		Limits:  []*Limit{{Path: "/sleep"}},
+		Handler: &TestHandler{},
+	}
+	if _, err := mux.GenerateHandler(); err == nil {
+		t.Fatal("ERROR")
+	}
+
+	// Mux を設定
+	mux = &Mux{
+		MaxClients:   2, // 同時受付リクエスト数(2リクエストまで)
+		QueueTimeout: 1, // 空き待ちの最大時間(1秒間)
+		Limits: []*Limit{
+			{Path: "/sleep", MaxClients: 2},        // /sleep 配下は2リクエストまで
+			{Path: "/sleep/limit/", MaxClients: 1}, // /sleep/limit 配下は1リクエストまで
+		},
+		Timeout:   10,             // リクエストタイムアウト(10秒間)
+		MaxMemory: 32,             // アップロードファイルの処理に使用する最大使用メモリ量(32MB)
+		Handler:   &TestHandler{}, // ハンドラを登録
+	}
+
+	// ハンドラを生成
+	handler, err := mux.GenerateHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mux.Close()
+
+	// 最も長いパスの制限が適用される
+	if limit := mux.limit("/sleep/limit/path"); limit == nil || limit.MaxClients != 1 {
+		t.Fatal("ERROR")
+	}
+	if limit := mux.limit("/"); limit != nil {
+		t.Fatal("ERROR")
+	}
+
+	// http サーバを立てる
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	// /sleep/limit の同時受付リクエスト数を埋める
+	client := ts.Client()
+	go client.Get(ts.URL + "/sleep/limit")
+	time.Sleep(500 * time.Millisecond)
+
+	// /sleep/limit へのリクエストは、空き待ちの最大時間を超過すると 503 エラーとなる
+	res, err := client.Get(ts.URL + "/sleep/limit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 503 {
+		t.Fatal(res.StatusCode)
+	}
+
+	// 制限のかかっていないパスへのリクエストは受け付ける
+	res, err = client.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 {
+		t.Fatal(res.StatusCode)
+	}
+}
+
+// パス毎のタイムアウト時間チェック
+func Test_RouteTimeouts(t *testing.T) {
+	// タイムアウト時間が未設定の場合はエラーとする
+	mux := &Mux{
+		RouteTimeouts: []*RouteTimeout{{Path: "/sleep"}},
+		Handler:       &TestHandler{},
+	}
+	if _, err := mux.GenerateHandler(); err == nil {
+		t.Fatal("ERROR")
+	}
+
+	// Mux を設定
+	mux = &Mux{
+		MaxClients: 2,  // 同時受付リクエスト数(2リクエストまで)
+		Timeout:    10, // リクエストタイムアウト(10秒間)
+		RouteTimeouts: []*RouteTimeout{
+			{Path: "/sleep", Timeout: 5},        // /sleep 配下は5秒間
+			{Path: "/sleep/limit/", Timeout: 1}, // /sleep/limit 配下は1秒間
+		},
+		MaxMemory: 32,             // アップロードファイルの処理に使用する最大使用メモリ量(32MB)
+		Handler:   &TestHandler{}, // ハンドラを登録
+	}
+
+	// ハンドラを生成
+	handler, err := mux.GenerateHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mux.Close()
+
+	// 最も長いパスのタイムアウト時間が適用される
+	if mux.timeout("/sleep/limit/path") != 1 || mux.timeout("/sleep") != 5 || mux.timeout("/") != 10 {
+		t.Fatal("ERROR")
+	}
+
+	// basemux.Mux が処理していないリクエストのタイムアウト時間は変更できない
+	if SetTimeout(httptest.NewRequest("GET", "/", nil), 1) {
+		t.Fatal("ERROR")
+	}
+
+	// http サーバを立てる
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	client := ts.Client()
+	for _, path := range []string{"/sleep/limit", "/settimeout"} {
+		// 設定したタイムアウト時間で 408 エラーとなる
+		res, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != 408 || res.Header.Get("X-Timeout") != "1" {
+			t.Fatal(path, res.StatusCode, res.Header.Get("X-Timeout"))
+		}
+	}
+
+	// タイムアウト時間内であれば、正常に処理する
+	res, err := client.Get(ts.URL + "/sleep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 {
+		t.Fatal(res.StatusCode)
+	}
+}
+
+// ファイルへ保存するストアのチェック
+func Test_FileStore(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	store := &BackendStore{
+		Backend: &FileBackend{Directory: "test/sessions"},
+		TTL:     60,
+	}
+	// 値を保存する
+	values := store.Create()
+	values.Set("key", "value")
+	values.Set("func", func() {})
+	// 変換できない値は保存せず、キーをエラーで返却する
+	if err, ok := store.Touch(values).(EncodeError); !ok || len(err) != 1 || err["func"] == nil {
+		t.Fatal(err)
+	}
+
+	// 再起動後を想定し、別のストアから値を取り出す
+	other := &BackendStore{
+		Backend: &FileBackend{Directory: "test/sessions"},
+		TTL:     60,
+	}
+	v := other.Get(values.ID())
+	if v == nil || v.Get("key") != "value" || v.Val("func") != nil || v.ID() != values.ID() {
+		t.Fatal("ERROR")
+	}
+	// 取り出した値から、過去のデータを取得できる
+	if v.Old(values.ID()) == nil {
+		t.Fatal("ERROR")
+	}
+	// 不正なIDや、存在しないIDの場合は nil を返却する
+	if other.Get("../sessions/"+values.ID()) != nil || other.Get("undefined") != nil {
+		t.Fatal("ERROR")
+	}
+	if other.Delete("../undefined") == nil {
+		t.Fatal("ERROR")
+	}
+
+	// TTL 未満のデータは削除しない
+	if err := other.Expire(0); err != nil {
+		t.Fatal(err)
+	}
+	if other.Get(values.ID()) == nil {
+		t.Fatal("ERROR")
+	}
+
+	// データを削除する
+	if err := other.Delete(values.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if other.Get(values.ID()) != nil {
+		t.Fatal("ERROR")
+	}
+
+	// TTL を超過したデータは削除する
+	expired := &BackendStore{
+		Backend: &FileBackend{Directory: "test/sessions"},
+		TTL:     1,
+	}
+	values = expired.Create()
+	if err := expired.Touch(values); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2100 * time.Millisecond)
+	if expired.Get(values.ID()) != nil {
+		t.Fatal("ERROR")
+	}
+	if err := expired.Expire(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ioutil.ReadDir("test/sessions"); len(files) != 0 {
+		t.Fatal(len(files))
+	}
+}
+
+// ビューで使用するヘルパ
+type testHelper struct {
+	Title  string
+	Params map[string]string
+	Link   func() string
+}
+
+// ビューの情報を、別のストアから取り出せるかチェック
+func Test_StoreViewData(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	store := &BackendStore{
+		Backend: &FileBackend{Directory: "test/sessions"},
+	}
+	// Create では保存しない
+	values := store.Create()
+	if files, _ := ioutil.ReadDir("test/sessions"); len(files) != 0 {
+		t.Fatal(len(files))
+	}
+	values.Set("ctlname", "Example")
+	values.Set("helper", &testHelper{
+		Title:  "TITLE",
+		Params: map[string]string{"controller": "Example"},
+		Link:   func() string { return "" },
+	})
+	values.Set("data", map[string]interface{}{
+		"name":  "NAME",
+		"items": []interface{}{1, "two"},
+	})
+	values.SetLocal("local", make(chan int))
+	if err := store.Touch(values); err != nil {
+		t.Fatal(err)
+	}
+
+	// 別のストアから、リンクIDで過去の情報を取り出す
+	other := &BackendStore{
+		Backend: &FileBackend{Directory: "test/sessions"},
+	}
+	v := other.Create().Old(values.ID())
+	if v == nil || v.Get("ctlname") != "Example" || v.Val("local") != nil {
+		t.Fatal("ERROR")
+	}
+	helper, ok := v.Val("helper").(*testHelper)
+	if !ok || helper.Title != "TITLE" || helper.Params["controller"] != "Example" {
+		t.Fatal(v.Val("helper"))
+	}
+	data, ok := v.Val("data").(map[string]interface{})
+	if !ok || data["name"] != "NAME" || fmt.Sprint(data["items"]) != "[1 two]" {
+		t.Fatal(v.Val("data"))
+	}
+}
+
+// ストアを指定した Mux のチェック
+func Test_MuxStore(t *testing.T) {
+	defer os.RemoveAll("test")
+
+	// Mux を設定
+	mux := &Mux{
+		Store: &BackendStore{
+			Backend: &FileBackend{Directory: "test/sessions"},
+		},
+		Handler: &TestHandler{}, // ハンドラを登録
+	}
+	// ハンドラを生成
+	handler, err := mux.GenerateHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mux.Close()
+	// http サーバを立てる
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	// リクエストを投入
+	client := ts.Client()
+	res, err := client.Get(ts.URL + "/value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var buf = make([]byte, res.ContentLength)
+	res.Body.Read(buf)
+	if string(buf) != "SUCCESS" {
+		t.Fatal(string(buf))
+	}
+	// リクエスト処理後、Values が保存される
+	files, _ := ioutil.ReadDir("test/sessions")
+	if len(files) != 1 || mux.Store.Get(files[0].Name()).Get("key") != "value" {
+		t.Fatal("ERROR")
+	}
+
+	// 値をセットしていないリクエスト、受け付けなかったリクエストの Values は保存しない
+	res, err = client.Get(ts.URL + "/header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	mux.Close()
+	res, err = client.Get(ts.URL + "/value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 503 {
+		t.Fatal(res.StatusCode)
+	}
+	if files, _ := ioutil.ReadDir("test/sessions"); len(files) != 1 {
+		t.Fatal(len(files))
+	}
+}
+
+// リンクIDの生成チェック
+func Test_GenerateID(t *testing.T) {
+	var ids = make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := generateID()
+		if len(id) != 32 || validID.MatchString(id) == false || ids[id] {
+			t.Fatal(id)
+		}
+		ids[id] = true
+	}
+}
+
+// クライアントに紐付けたリンクIDのチェック
+func Test_LinkKey(t *testing.T) {
+	// 署名鍵が短い場合はエラーとする
+	mux := &Mux{
+		LinkKey: []byte("short"),
+		Handler: &TestHandler{},
+	}
+	if _, err := mux.GenerateHandler(); err == nil {
+		t.Fatal("ERROR")
+	}
+
+	// X-Owner ヘッダの値を、クライアントの識別子とする
+	mux = &Mux{
+		LinkKey: []byte("0123456789abcdef"),
+		LinkOwner: func(r *http.Request, v *Values) string {
+			return r.Header.Get("X-Owner")
+		},
+		Handler: &TestHandler{},
+	}
+	handler, err := mux.GenerateHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mux.Close()
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	request := func(path, owner string) string {
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		req.Header.Set("X-Owner", owner)
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		buf, _ := ioutil.ReadAll(res.Body)
+		return string(buf)
+	}
+
+	// リンクIDには署名が付与される
+	linkid := request("/link", "user1")
+	if len(linkid) != 64 || validID.MatchString(linkid) == false {
+		t.Fatal(linkid)
+	}
+	// 同じクライアントであれば、過去の情報を取得できる
+	if result := request("/link/old?id="+linkid, "user1"); result != "FOUND" {
+		t.Fatal(result)
+	}
+	// 別のクライアント、署名のないリンクID、改ざんされたリンクIDの場合は取得できない
+	if result := request("/link/old?id="+linkid, "user2"); result != "NOTFOUND" {
+		t.Fatal(result)
+	}
+	if result := request("/link/old?id="+linkid[:32], "user1"); result != "NOTFOUND" {
+		t.Fatal(result)
+	}
+	if result := request("/link/old?id="+linkid[:63]+"0", "user1"); result != "NOTFOUND" && linkid[63] != '0' {
+		t.Fatal(result)
+	}
+}
+
+// レスポンスボディを逐次書き込む Render のチェック
+func Test_Stream(t *testing.T) {
+	mux := &Mux{
+		Timeout: 1,
+		Handler: &TestHandler{},
+	}
+	handler, err := mux.GenerateHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mux.Close()
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	// ヘッダとレスポンスボディが出力される
+	res, err := ts.Client().Get(ts.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(buf) != "0,0\n1,1\n2,2\n" || res.ContentLength != 12 || res.Header.Get("Content-Type") != "text/csv" ||
+		res.Header.Get("Content-Disposition") != "attachment; filename=test.csv" {
+		t.Fatal(string(buf), res.Header)
+	}
+
+	// 書き込み前のエラーは、エラー画面を出力する
+	res, err = ts.Client().Get(ts.URL + "/stream/error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 500 || string(buf) != "STREAM ERROR" {
+		t.Fatal(res.StatusCode, string(buf))
+	}
+
+	// 書き込み開始後は、タイムアウトしない
+	res, err = ts.Client().Get(ts.URL + "/stream/timeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 200 || string(buf) != "BEGINEND" {
+		t.Fatal(res.StatusCode, string(buf))
+	}
+
+	// 書き込み前にタイムアウトした場合は、エラー画面を出力する
+	res, err = ts.Client().Get(ts.URL + "/stream/wait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 408 {
+		t.Fatal(res.StatusCode)
+	}
+}
+
+// Server-Sent Events のチェック
+func Test_EventStream(t *testing.T) {
+	mux := &Mux{
+		Timeout: 1,
+		Handler: &TestHandler{},
+	}
+	handler, err := mux.GenerateHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	// lines 行分のイベントを読み込む
+	read := func(lastid string, lines int) (*http.Response, []string) {
+		req, _ := http.NewRequest("GET", ts.URL+"/events", nil)
+		if lastid != "" {
+			req.Header.Set("Last-Event-ID", lastid)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result []string
+		scanner := bufio.NewScanner(res.Body)
+		for len(result) < lines && scanner.Scan() {
+			result = append(result, scanner.Text())
+		}
+		return res, result
+	}
+
+	// タイムアウト時間を超えても、イベントを受信できる
+	res, lines := read("", 2+5*4)
+	res.Body.Close()
+	if res.Header.Get("Content-Type") != "text/event-stream" || res.Header.Get("Cache-Control") != "no-cache" {
+		t.Fatal(res.Header)
+	}
+	expect := "retry: 3000||id: 1|event: progress|data: line1|data: line2||id: 2|event: progress|data: line1|data: line2|"
+	if strings.Join(lines[:12], "|") != expect || lines[len(lines)-5] != "id: 4" {
+		t.Fatal(strings.Join(lines, "|"))
+	}
+
+	// Last-Event-ID を指定した場合は、続きのイベントを受信する
+	res, lines = read("10", 2+5)
+	res.Body.Close()
+	if lines[2] != "id: 11" {
+		t.Fatal(strings.Join(lines, "|"))
+	}
+
+	// シャットダウン時は、接続中のイベントストリームを終了する
+	res, _ = read("", 3)
+	defer res.Body.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := mux.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(res.Body); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 接続の乗っ取りのチェック
+func Test_Hijack(t *testing.T) {
+	mux := &Mux{
+		Timeout: 1,
+		Handler: &TestHandler{},
+	}
+	handler, err := mux.GenerateHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mux.Close()
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	// タイムアウト時間を超えても、乗っ取った接続へ書き込める
+	res, err := ts.Client().Get(ts.URL + "/hijack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(buf) != "HIJACK" {
+		t.Fatal(string(buf))
+	}
+}
+
+// レスポンス圧縮のチェック
+func Test_Compress(t *testing.T) {
+	mux := &Mux{
+		Handler:  &TestHandler{},
+		Compress: &Compress{},
+	}
+	handler, err := mux.GenerateHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mux.Close()
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	request := func(path, encoding string) (*http.Response, []byte) {
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		res, err := ts.Client().Transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		buf, _ := ioutil.ReadAll(res.Body)
+		return res, buf
+	}
+	expect := strings.Repeat("COMPRESS", 256)
+
+	// gzip に対応している場合は、gzip で圧縮する
+	res, buf := request("/compress", "deflate;q=0.5, gzip")
+	if res.Header.Get("Content-Encoding") != "gzip" || res.Header.Get("Vary") != "Accept-Encoding" || len(buf) >= len(expect) {
+		t.Fatal(res.Header)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(gz); string(b) != expect {
+		t.Fatal(string(b))
+	}
+	// 優先度の高い圧縮形式を使用する
+	res, buf = request("/compress", "gzip;q=0.5, deflate")
+	if res.Header.Get("Content-Encoding") != "deflate" {
+		t.Fatal(res.Header)
+	}
+	if b, _ := ioutil.ReadAll(flate.NewReader(bytes.NewReader(buf))); string(b) != expect {
+		t.Fatal(string(b))
+	}
+	// 圧縮形式に対応していない場合は圧縮しないが、Vary は付与する
+	res, buf = request("/compress", "gzip;q=0, br")
+	if res.Header.Get("Content-Encoding") != "" || res.Header.Get("Vary") != "Accept-Encoding" || string(buf) != expect {
+		t.Fatal(res.Header)
+	}
+	// 最小サイズ未満のレスポンスは圧縮しない
+	res, buf = request("/", "gzip")
+	if res.Header.Get("Content-Encoding") != "" || string(buf) != "HELLO WORLD" {
+		t.Fatal(res.Header, string(buf))
+	}
+	// テキスト形式以外のレスポンスは圧縮しない
+	res, buf = request("/compress/image", "*")
+	if res.Header.Get("Content-Encoding") != "" || res.Header.Get("Vary") != "" || string(buf) != expect {
+		t.Fatal(res.Header)
+	}
+	// Content-Length が指定されている場合は、そのサイズで圧縮の有無を判定する
+	res, buf = request("/stream", "gzip")
+	if res.Header.Get("Content-Encoding") != "" || string(buf) != "0,0\n1,1\n2,2\n" {
+		t.Fatal(res.Header, string(buf))
+	}
+}
+
+// Range リクエストのチェック
+func Test_FileView(t *testing.T) {
+	os.MkdirAll("testdata", 0755)
+	ioutil.WriteFile("testdata/range.txt", []byte("0123456789"), 0644)
+	defer os.RemoveAll("testdata")
+
+	mux := &Mux{
+		Handler: &TestHandler{},
+	}
+	handler, err := mux.GenerateHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mux.Close()
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	request := func(header http.Header) (*http.Response, string) {
+		req, _ := http.NewRequest("GET", ts.URL+"/file", nil)
+		req.Header = header
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		buf, _ := ioutil.ReadAll(res.Body)
+		return res, string(buf)
+	}
+
+	// Range 未指定の場合は、ファイル全体を返却する
+	res, body := request(http.Header{})
+	etag := res.Header.Get("ETag")
+	if res.StatusCode != 200 || body != "0123456789" || res.Header.Get("Accept-Ranges") != "bytes" || etag == "" ||
+		res.Header.Get("Cache-Control") != "no-cache" || res.Header.Get("Content-Type") != "text/plain" {
+		t.Fatal(res.StatusCode, res.Header)
+	}
+	// 単一範囲の場合は、206 で指定範囲を返却する
+	res, body = request(http.Header{"Range": {"bytes=2-5"}})
+	if res.StatusCode != 206 || body != "2345" || res.Header.Get("Content-Range") != "bytes 2-5/10" {
+		t.Fatal(res.StatusCode, body, res.Header)
+	}
+	// 複数範囲の場合は、multipart/byteranges で返却する
+	res, body = request(http.Header{"Range": {"bytes=0-1,8-"}})
+	if res.StatusCode != 206 || !strings.HasPrefix(res.Header.Get("Content-Type"), "multipart/byteranges") ||
+		!strings.Contains(body, "Content-Range: bytes 0-1/10") || !strings.Contains(body, "Content-Range: bytes 8-9/10") {
+		t.Fatal(res.StatusCode, body)
+	}
+	// If-Range が一致する場合は指定範囲を、一致しない場合はファイル全体を返却する
+	res, body = request(http.Header{"Range": {"bytes=2-5"}, "If-Range": {etag}})
+	if res.StatusCode != 206 || body != "2345" {
+		t.Fatal(res.StatusCode, body)
+	}
+	res, body = request(http.Header{"Range": {"bytes=2-5"}, "If-Range": {`"other"`}})
+	if res.StatusCode != 200 || body != "0123456789" {
+		t.Fatal(res.StatusCode, body)
+	}
+	// 範囲外の場合は 416 を返却する
+	if res, _ = request(http.Header{"Range": {"bytes=20-"}}); res.StatusCode != 416 {
+		t.Fatal(res.StatusCode)
+	}
+	// ETag が一致する場合は 304 を返却する
+	if res, _ = request(http.Header{"If-None-Match": {etag}}); res.StatusCode != 304 {
+		t.Fatal(res.StatusCode)
+	}
+
+	// 送信開始後は、タイムアウトを無効にする
+	w := httptest.NewRecorder()
+	tw := newTimeoutWriter(w)
+	tc := newTimeoutContext(context.Background(), 60, tw.timeout, nil)
+	defer tc.stop()
+	r := httptest.NewRequest("GET", "/file", nil).WithContext(tc)
+	(&FileView{Name: "testdata/range.txt"}).Render(tw, r)
+	if w.Body.String() != "0123456789" || SetTimeout(r, 120) {
+		t.Fatal(w.Body.String())
+	}
+	if _, ok := tc.Deadline(); ok {
+		t.Fatal("ERROR")
+	}
+	// タイムアウト後は送信を中断する
+	w = httptest.NewRecorder()
+	tw = newTimeoutWriter(w)
+	tw.timeout()
+	(&FileView{Name: "testdata/range.txt"}).Render(tw, r)
+	if w.Body.Len() != 0 {
+		t.Fatal(w.Body.String())
+	}
+}
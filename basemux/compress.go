@@ -0,0 +1,300 @@
+package basemux
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Encoder : Content-Encoding に対応した圧縮処理
+type Encoder struct {
+	Name string                                    // Content-Encoding に指定する名前(ex: gzip)
+	New  func(w io.Writer) (io.WriteCloser, error) // w へ圧縮した内容を書き込む io.WriteCloser を生成する関数
+}
+
+// Compress : レスポンス圧縮の設定
+type Compress struct {
+	Disable  bool       // 圧縮を無効にする
+	MinSize  int        // 圧縮する最小のレスポンスサイズ(未設定の場合は1024バイト)
+	Level    int        // デフォルトの圧縮処理で使用する圧縮レベル(未設定の場合は gzip.DefaultCompression)
+	Types    []string   // 圧縮する Content-Type(未設定の場合は ContentList のテキスト形式)
+	Encoders []*Encoder // 使用する圧縮処理。Accept-Encoding の優先度が同じ場合は、先頭の圧縮処理を使用する(未設定の場合は gzip, deflate)
+	types    map[string]bool
+}
+
+// 設定値を検証し、デフォルト値を適用する
+func (c *Compress) init() error {
+	if c.MinSize <= 0 {
+		c.MinSize = 1024
+	}
+	if c.Level == 0 {
+		c.Level = gzip.DefaultCompression
+	}
+	if c.Level < gzip.HuffmanOnly || c.Level > gzip.BestCompression {
+		return fmt.Errorf("invalid compression level %d", c.Level)
+	}
+	if c.Types == nil {
+		c.Types = TextTypes(ContentList())
+	}
+	c.types = make(map[string]bool, len(c.Types))
+	for _, t := range c.Types {
+		c.types[strings.ToLower(t)] = true
+	}
+	if c.Encoders == nil {
+		level := c.Level
+		c.Encoders = []*Encoder{
+			{Name: "gzip", New: func(w io.Writer) (io.WriteCloser, error) {
+				return gzip.NewWriterLevel(w, level)
+			}},
+			{Name: "deflate", New: func(w io.Writer) (io.WriteCloser, error) {
+				return flate.NewWriter(w, level)
+			}},
+		}
+	}
+	for _, enc := range c.Encoders {
+		if enc == nil || enc.Name == "" || enc.New == nil {
+			return fmt.Errorf("invalid encoder")
+		}
+	}
+	return nil
+}
+
+// Compressible : 圧縮する Content-Type か否かを判定する
+func (c *Compress) Compressible(contentType string) bool {
+	t := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	return c.types[t]
+}
+
+// Negotiate : Accept-Encoding から、使用する圧縮処理を選定する。該当する圧縮処理がない場合は nil を返却する
+func (c *Compress) Negotiate(r *http.Request) *Encoder {
+	var result *Encoder
+	var max float64
+	for _, enc := range c.Encoders {
+		if q := AcceptEncoding(r, enc.Name); q > max {
+			result, max = enc, q
+		}
+	}
+	return result
+}
+
+// AcceptEncoding : Accept-Encoding に指定された、圧縮形式の優先度(q値)を返却する。受け付けない場合は0を返却する
+func AcceptEncoding(r *http.Request, name string) float64 {
+	var wildcard float64
+	for _, v := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(v, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		switch coding {
+		case strings.ToLower(name):
+			return q
+		case "*":
+			wildcard = q
+		}
+	}
+	return wildcard
+}
+
+// TextTypes : Content-Type リストから、テキスト形式の Content-Type を抽出する
+func TextTypes(list map[string]string) []string {
+	var types = make(map[string]bool)
+	for _, t := range list {
+		t = strings.ToLower(t)
+		if strings.HasPrefix(t, "text/") || strings.HasSuffix(t, "json") || strings.HasSuffix(t, "xml") ||
+			strings.HasSuffix(t, "javascript") || strings.HasSuffix(t, "typescript") {
+			types[t] = true
+		}
+	}
+	var result []string
+	for t := range types {
+		result = append(result, t)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Content-Type、サイズに応じて、レスポンスを圧縮する http.ResponseWriter
+type compressWriter struct {
+	http.ResponseWriter
+	compress    *Compress
+	encoder     *Encoder       // Accept-Encoding から選定した圧縮処理
+	head        bool           // HEAD リクエストか否か
+	code        int            // 書き込むステータスコード
+	wroteHeader bool           // WriteHeader がコールされたか否か
+	decided     bool           // 圧縮の有無を決定済みか否か
+	buf         []byte         // 圧縮の有無を決定するまで、書き込み内容を保持するバッファ
+	writer      io.WriteCloser // 圧縮処理。圧縮しない場合は nil
+	hijacked    bool           // 接続を乗っ取られたか否か
+}
+
+// compressWriter を生成する
+func newCompressWriter(w http.ResponseWriter, r *http.Request, c *Compress) *compressWriter {
+	return &compressWriter{
+		ResponseWriter: w,
+		compress:       c,
+		encoder:        c.Negotiate(r),
+		head:           r.Method == "HEAD",
+	}
+}
+
+// WriteHeader : ステータスコードを記憶する。ヘッダは、圧縮の有無を決定した時点で書き込む
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.code = code
+
+	header := cw.Header()
+	// 圧縮対象の Content-Type の場合、キャッシュがエンコーディング毎に保存されるよう Vary を付与する
+	if cw.compress.Compressible(header.Get("Content-Type")) {
+		AddVary(header, "Accept-Encoding")
+	} else {
+		cw.start(false)
+		return
+	}
+	// 圧縮済み、部分的なレスポンス、ボディのないレスポンス等は圧縮しない
+	if cw.encoder == nil || cw.head || header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" ||
+		code < 200 || code == http.StatusNoContent || code == http.StatusPartialContent || code == http.StatusNotModified {
+		cw.start(false)
+		return
+	}
+	// サイズが判明している場合は、その場で圧縮の有無を決定する
+	if length := header.Get("Content-Length"); length != "" {
+		size, err := strconv.Atoi(length)
+		cw.start(err == nil && size >= cw.compress.MinSize)
+	}
+}
+
+// Write : 圧縮の有無を決定するまでは、バッファへ書き込む
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.wroteHeader == false {
+		// Content-Type 未設定の場合は、内容から判定する
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.writer != nil {
+			return cw.writer.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	// 最小サイズを超えた場合は、圧縮を開始する
+	if len(cw.buf) >= cw.compress.MinSize {
+		if err := cw.flushBuffer(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// 圧縮の有無を決定し、ヘッダを書き込む
+func (cw *compressWriter) start(compress bool) {
+	cw.decided = true
+	if compress {
+		writer, err := cw.encoder.New(cw.ResponseWriter)
+		if err == nil {
+			header := cw.Header()
+			header.Del("Content-Length")
+			header.Set("Content-Encoding", cw.encoder.Name)
+			// 圧縮後の内容は元の内容とバイト単位で一致しないため、ETag を弱いETagとする
+			if etag := header.Get("ETag"); etag != "" && strings.HasPrefix(etag, "W/") == false {
+				header.Set("ETag", "W/"+etag)
+			}
+			cw.writer = writer
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.code)
+}
+
+// 圧縮の有無を決定し、バッファの内容を書き込む
+func (cw *compressWriter) flushBuffer(compress bool) error {
+	cw.start(compress)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.writer != nil {
+		_, err = cw.writer.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush : 書き込んだ内容をクライアントへフラッシュする。逐次書き込むレスポンスは、最小サイズ未満でも圧縮する
+func (cw *compressWriter) Flush() {
+	if cw.wroteHeader == false {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided == false {
+		cw.flushBuffer(true)
+	}
+	if f, ok := cw.writer.(interface {
+		Flush() error
+	}); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack : 接続を乗っ取る。乗っ取り後は圧縮しない
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not implement http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		cw.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// 圧縮の有無が未決定の場合は最小サイズ未満のため圧縮せずに書き込み、圧縮処理を終了する
+func (cw *compressWriter) close() error {
+	if cw.hijacked || cw.wroteHeader == false {
+		return nil
+	}
+	if cw.decided == false {
+		return cw.flushBuffer(false)
+	}
+	if cw.writer != nil {
+		return cw.writer.Close()
+	}
+	return nil
+}
+
+// AddVary : Vary ヘッダに値を追加する。既に含まれている場合、または * の場合は何もしない
+func AddVary(header http.Header, value string) {
+	for _, v := range header["Vary"] {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
@@ -44,6 +44,7 @@ type TimeoutError struct {
 	Title      string // エラータイトル
 	Message    string // エラーメッセージ
 	StatusCode int    // ステータスコード
+	Timeout    int    // 設定されていたタイムアウト時間(秒単位)
 }
 
 func (p *TimeoutError) Error() string {
@@ -55,27 +56,49 @@ type MaxClientsError struct {
 	Title      string // エラータイトル
 	Message    string // エラーメッセージ
 	StatusCode int    // ステータスコード
+	RetryAfter int    // 再試行までの秒数
 }
 
 func (p *MaxClientsError) Error() string {
 	return p.Message
 }
 
-// タイムアウトエラー、または最大同時リクエスト数が超過した場合コールする
-func accessError(status int) error {
-	var err error
-	if status == 408 {
-		err = &TimeoutError{
-			Title:      "408 Request Time-out",
-			Message:    fmt.Sprintf("request time-out"),
-			StatusCode: status,
-		}
-	} else if status == 503 {
-		err = &MaxClientsError{
-			Title:      "503 Service Temporarily Unavailable",
-			Message:    fmt.Sprintf("max clients number of limit exceeded"),
-			StatusCode: status,
-		}
+// ShutdownError : シャットダウン中にリクエストを受け付けた際のエラー型
+type ShutdownError struct {
+	Title      string // エラータイトル
+	Message    string // エラーメッセージ
+	StatusCode int    // ステータスコード
+}
+
+func (p *ShutdownError) Error() string {
+	return p.Message
+}
+
+// シャットダウン中にリクエストを受け付けた場合コールする
+func shutdownError() error {
+	return &ShutdownError{
+		Title:      "503 Service Temporarily Unavailable",
+		Message:    "server is shutting down",
+		StatusCode: 503,
+	}
+}
+
+// タイムアウトした場合コールする
+func timeoutError(timeout int) error {
+	return &TimeoutError{
+		Title:      "408 Request Time-out",
+		Message:    "request time-out",
+		StatusCode: 408,
+		Timeout:    timeout,
+	}
+}
+
+// 最大同時リクエスト数が超過した場合コールする
+func maxClientsError(retry int) error {
+	return &MaxClientsError{
+		Title:      "503 Service Temporarily Unavailable",
+		Message:    "max clients number of limit exceeded",
+		StatusCode: 503,
+		RetryAfter: retry,
 	}
-	return err
 }
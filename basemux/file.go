@@ -0,0 +1,64 @@
+package basemux
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// FileView : ディスク上のファイルを、Range リクエストに対応して送信する構造体
+// Accept-Ranges、単一/複数範囲の 206 レスポンス、If-Range、If-None-Match、If-Modified-Since に対応する
+// ファイルはメモリへ読み込まずに逐次送信する。ヘッダを出力した時点でタイムアウトを無効にし、動画などの大きなファイルの送信を中断しない
+type FileView struct {
+	Name         string      // 送信するファイルのパス
+	ContentType  string      // Content-Type(未設定の場合は、拡張子、または内容から判定する)
+	ETag         string      // ETag(未設定の場合は、ファイルサイズと更新日時から生成する)
+	CacheControl string      // Cache-Control
+	Header       http.Header // 追加で出力するヘッダ
+}
+
+// Render : ファイルを送信する
+func (f *FileView) Render(w http.ResponseWriter, r *http.Request) {
+	if err := f.Stream(w, r); err != nil {
+		log.Println(err)
+	}
+}
+
+// Stream : ファイルを送信する
+func (f *FileView) Stream(w http.ResponseWriter, r *http.Request) error {
+	file, err := os.Open(f.Name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s: is a directory", f.Name)
+	}
+
+	header := w.Header()
+	for k, v := range f.Header {
+		header[k] = v
+	}
+	if f.ContentType != "" {
+		header.Set("Content-Type", f.ContentType)
+	}
+	if f.CacheControl != "" {
+		header.Set("Cache-Control", f.CacheControl)
+	}
+	// If-Range で使用するため、ファイルサイズと更新日時から強いETagを生成する
+	etag := f.ETag
+	if etag == "" {
+		etag = fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+	}
+	header.Set("ETag", etag)
+
+	// 送信開始後は、タイムアウトを無効にする
+	http.ServeContent(&untimedWriter{ResponseWriter: w, r: r}, r, filepath.Base(f.Name), info.ModTime(), file)
+	return nil
+}
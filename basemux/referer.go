@@ -2,30 +2,32 @@ package basemux
 
 import (
 	"container/list"
-	"crypto/md5"
+	"crypto/rand"
 	"fmt"
 	"io"
-	"math/rand"
 	"sync"
 	"time"
 )
 
-var random = rand.New(rand.NewSource(time.Now().UnixNano()))
-
 // referer 構造体を生成する
 func newRefer(latency time.Duration) *referer {
 	r := &referer{
 		list: list.New(),
 		data: make(map[string]*list.Element),
+		stop: make(chan struct{}),
 	}
 
 	t := time.NewTicker(latency * time.Second)
 	go func() {
+		defer t.Stop()
 		for {
 			select {
 			// latency に設定した値を元に、データの期限切れをチェックして削除する
 			case <-t.C:
 				r.inspection(latency)
+			// Close がコールされた場合、期限切れチェックを終了する
+			case <-r.stop:
+				return
 			}
 		}
 	}()
@@ -36,27 +38,33 @@ type referer struct {
 	mu   sync.Mutex
 	list *list.List
 	data map[string]*list.Element
+	stop chan struct{}
+	once sync.Once
+}
+
+// 期限切れチェックを停止する
+func (r *referer) Close() {
+	r.once.Do(func() {
+		close(r.stop)
+	})
 }
 
-// 新規リンクIDを生成する
-func (r *referer) generateID() string {
-	// ランダムIDを生成するための、元データ
-	const randomid = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
-	// シード値を設定
-	random.Seed(time.Now().UnixNano())
-	// ランダム文字列を生成
-	buf := make([]byte, 64)
-	for i := range buf {
-		buf[i] = randomid[random.Intn(len(randomid))]
+// 新規リンクIDを生成する。推測されないよう、crypto/rand で生成した128ビットの乱数を16進数で表記する
+func generateID() string {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		panic(err)
 	}
-	// SHA256オブジェクト形式のランダムIDを生成する
-	hash := md5.New()
-	io.WriteString(hash, string(buf)+fmt.Sprint(time.Now().UnixNano()))
-	return fmt.Sprintf("%X", hash.Sum(nil))
+	return fmt.Sprintf("%X", buf)
 }
 
 // リンク切れの情報が存在しないかチェックする
 func (r *referer) inspection(latency time.Duration) {
+	r.Expire(latency * 2 * time.Second)
+}
+
+// Expire : 最終アクセスから ttl 以上経過したデータを削除する
+func (r *referer) Expire(ttl time.Duration) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -69,7 +77,7 @@ func (r *referer) inspection(latency time.Duration) {
 		}
 		// アクセス履歴が見つかった場合、期限切れチェックを行う
 		data := elem.Value.(*Values)
-		access := data.access + int64(latency*2)
+		access := data.access + int64(ttl/time.Second)
 		if access < time.Now().Unix() {
 			// 期限切れデータを破棄
 			delete(r.data, data.id)
@@ -79,6 +87,7 @@ func (r *referer) inspection(latency time.Duration) {
 			break
 		}
 	}
+	return nil
 }
 
 // リファラのIDをキーに、登録されているデータを取り出す
@@ -92,32 +101,59 @@ func (r *referer) Get(id string) *Values {
 		return nil
 	}
 
-	// 登録データのaccessを更新
+	// 登録データのaccessを更新し、最後尾へ移動する
 	values := v.Value.(*Values)
 	values.access = time.Now().Unix()
+	r.list.MoveToBack(v)
 
 	// 登録データを返却する
 	return values
 }
 
-// リファラに登録するデータを作成する
-func (r *referer) Create() *Values {
+// Touch : 登録データのaccessを更新する
+func (r *referer) Touch(values *Values) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	// 後方に、生成するデータを追加する
-	v := &Values{
-		access: time.Now().Unix(),
-		id:     r.generateID(),
-		data:   make(map[string]interface{}),
-		old:    r,
+	// 期限切れなどで登録データが存在しない場合は、再登録する
+	v, ok := r.data[values.id]
+	if !ok {
+		values.access = time.Now().Unix()
+		r.data[values.id] = r.list.PushBack(values)
+		return nil
 	}
-	r.data[v.id] = r.list.PushBack(v)
+	values.access = time.Now().Unix()
+	r.list.MoveToBack(v)
+	return nil
+}
 
-	return v
+// Delete : 登録データを削除する
+func (r *referer) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if v, ok := r.data[id]; ok {
+		delete(r.data, id)
+		r.list.Remove(v)
+	}
+	return nil
+}
+
+// リファラに登録するデータを作成する。データは Touch で登録する
+func (r *referer) Create() *Values {
+	return NewValues(generateID(), nil, r)
 }
 
 // Referer : リファラを取り扱うインタフェース
 type Referer interface {
 	Get(string) *Values
 }
+
+// SessionStore : Values を保存するストアのインタフェース。デフォルトでは、メモリ上に保存するリファラを使用する
+type SessionStore interface {
+	Referer
+	Create() *Values            // 新規リンクIDで Values を作成する。Touch で保存するまでは登録しない
+	Touch(*Values) error        // Values を保存し、最終アクセス時刻を更新する
+	Delete(string) error        // 指定したリンクIDの Values を削除する
+	Expire(time.Duration) error // 最終アクセスから指定時間以上経過した Values を削除する
+}
@@ -0,0 +1,124 @@
+package basemux
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Event : Server-Sent Events で送信するイベント
+type Event struct {
+	ID    string // イベントID。再接続時に Last-Event-ID として送信される
+	Name  string // イベント名。未設定の場合は message イベントとなる
+	Data  string // 送信するデータ。改行を含む場合は、複数の data フィールドに分割して送信する
+	Retry int    // 再接続までの待ち時間(ミリ秒単位)。0の場合は送信しない
+}
+
+// EventWriter : Server-Sent Events のイベントを送信する構造体
+type EventWriter struct {
+	w      http.ResponseWriter
+	r      *http.Request
+	lastID string
+}
+
+// LastEventID : 再接続時に、クライアントが最後に受信したイベントIDを返却する。初回接続時は空文字列を返却する
+func (ew *EventWriter) LastEventID() string {
+	return ew.lastID
+}
+
+// Context : リクエストのコンテキストを返却する。クライアントの切断時、またはシャットダウン時に Done が通知される
+func (ew *EventWriter) Context() context.Context {
+	return ew.r.Context()
+}
+
+// Send : イベントを送信し、クライアントへフラッシュする
+func (ew *EventWriter) Send(event *Event) error {
+	var buf strings.Builder
+	if event.ID != "" {
+		buf.WriteString("id: " + eventField(event.ID) + "\n")
+	}
+	if event.Name != "" {
+		buf.WriteString("event: " + eventField(event.Name) + "\n")
+	}
+	if event.Retry > 0 {
+		buf.WriteString(fmt.Sprintf("retry: %d\n", event.Retry))
+	}
+	data := strings.Replace(event.Data, "\r\n", "\n", -1)
+	for _, line := range strings.Split(data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	return ew.write(buf.String())
+}
+
+// Comment : コメントを送信する。接続を維持するための keep-alive として使用する
+func (ew *EventWriter) Comment(text string) error {
+	return ew.write(": " + eventField(text) + "\n\n")
+}
+
+// イベントを書き込み、クライアントへフラッシュする
+func (ew *EventWriter) write(s string) error {
+	if _, err := ew.w.Write([]byte(s)); err != nil {
+		return err
+	}
+	if f, ok := ew.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// id, event フィールドに使用できない改行文字を除去する
+func eventField(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// EventStreamView : Server-Sent Events を送信する Streamer
+// 送信中はタイムアウトを無効にし、クライアントの切断時、またはシャットダウン時に EventWriter.Context を終了する
+type EventStreamView struct {
+	Header http.Header              // 追加で出力するヘッダ
+	Retry  int                      // 再接続までの待ち時間(ミリ秒単位)。0の場合は送信しない
+	Body   func(*EventWriter) error // イベントを送信する関数
+}
+
+// Render : イベントを送信する。エラーが発生した場合は、ログへ出力する
+func (v *EventStreamView) Render(w http.ResponseWriter, r *http.Request) {
+	if err := v.Stream(w, r); err != nil {
+		log.Println(err)
+	}
+}
+
+// Stream : ヘッダを出力し、イベントを送信する
+func (v *EventStreamView) Stream(w http.ResponseWriter, r *http.Request) error {
+	// 接続を維持するため、タイムアウトを無効にする
+	DisableTimeout(r)
+
+	header := w.Header()
+	for k, val := range v.Header {
+		header[k] = val
+	}
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	// リバースプロキシでのバッファリングを無効にする
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+
+	// 再接続時は、Last-Event-ID ヘッダ、または lastEventId クエリパラメータで最後に受信したイベントIDが送信される
+	ew := &EventWriter{w: w, r: r, lastID: r.Header.Get("Last-Event-ID")}
+	if ew.lastID == "" {
+		ew.lastID = r.URL.Query().Get("lastEventId")
+	}
+	// 再接続までの待ち時間を送信する
+	if v.Retry > 0 {
+		if err := ew.write(fmt.Sprintf("retry: %d\n\n", v.Retry)); err != nil {
+			return err
+		}
+	} else if err := ew.write(": ok\n\n"); err != nil {
+		return err
+	}
+	if v.Body == nil {
+		return nil
+	}
+	return v.Body(ew)
+}
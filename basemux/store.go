@@ -0,0 +1,241 @@
+package basemux
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	// ビューのデータとして使用する汎用の型を登録する
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+}
+
+// リンクIDとして使用可能な文字列
+var validID = regexp.MustCompile(`^[0-9A-Za-z]+$`)
+
+// Backend : BackendStore が使用する、データの読み書きを行うインタフェース
+// Redis などの外部ストレージを使用する場合は、このインタフェースを実装する
+type Backend interface {
+	Load(id string) ([]byte, error)                       // データを読み込む。存在しない場合は nil, nil を返却する
+	Save(id string, data []byte, ttl time.Duration) error // データを保存する。ttl 経過後に削除してよい
+	Remove(id string) error                               // データを削除する
+	Expire(ttl time.Duration) error                       // 最終更新から ttl 以上経過したデータを削除する
+}
+
+// Backend へ保存するデータの形式
+type record struct {
+	Access int64
+	Data   map[string][]byte
+}
+
+// EncodeError : BackendStore で保存できなかった値のキーと、変換時のエラー
+type EncodeError map[string]error
+
+func (err EncodeError) Error() string {
+	var keys []string
+	for key := range err {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var messages []string
+	for _, key := range keys {
+		messages = append(messages, fmt.Sprintf("'%s': %s", key, err[key]))
+	}
+	return "values not saved: " + strings.Join(messages, ", ")
+}
+
+// BackendStore : Backend を使用して Values を保存する SessionStore
+// 値は encoding/gob で変換し、リクエスト処理後の Touch で一度だけ保存する。値をセットしていないリクエストの Values は保存しない
+// 保存できる値は、基本型、map[string]interface{}、[]interface{}、公開フィールドを持つ構造体とそのポインタ、スライス、マップとなる
+// 値の型は保存時に gob.Register で登録する。interface{} のフィールドや要素に格納する独自の型は、事前に gob.Register で登録すること
+// 関数、チャネル型のフィールドは保存しない。関数、チャネルなど変換できない値は保存せず、Touch で EncodeError を返却する
+// SetLocal でセットした値は保存しない
+type BackendStore struct {
+	Backend Backend // データの読み書きを行うインタフェース
+	TTL     int     // 最終アクセスからデータを破棄するまでの秒数(未設定の場合は120秒)
+}
+
+// 最終アクセスからデータを破棄するまでの時間を返却する
+func (s *BackendStore) ttl() time.Duration {
+	if s.TTL <= 0 {
+		return 120 * time.Second
+	}
+	return time.Duration(s.TTL) * time.Second
+}
+
+// Get : リンクIDをキーに、保存されているデータを取り出す
+func (s *BackendStore) Get(id string) *Values {
+	if validID.MatchString(id) == false {
+		return nil
+	}
+	// 保存データが存在しない場合 nil を返却する
+	buf, err := s.Backend.Load(id)
+	if err != nil || buf == nil {
+		return nil
+	}
+	var rec record
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&rec); err != nil {
+		return nil
+	}
+	// 期限切れのデータは返却しない
+	if rec.Access+int64(s.ttl()/time.Second) < time.Now().Unix() {
+		return nil
+	}
+
+	// 保存データを復元する
+	v := NewValues(id, nil, s)
+	v.access = rec.Access
+	for key, b := range rec.Data {
+		var val interface{}
+		if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&val); err != nil {
+			continue
+		}
+		v.data[key] = val
+	}
+	return v
+}
+
+// Create : 新規リンクIDで Values を作成する。Values はリクエスト処理後の Touch で保存する
+func (s *BackendStore) Create() *Values {
+	return NewValues(generateID(), nil, s)
+}
+
+// Touch : Values を保存し、最終アクセス時刻を更新する
+// 変換できない値は保存せず、他の値を保存した後に EncodeError を返却する
+func (s *BackendStore) Touch(v *Values) error {
+	var rec = record{
+		Access: time.Now().Unix(),
+		Data:   make(map[string][]byte),
+	}
+	// 値毎に変換する
+	var encodeErr = make(EncodeError)
+	for key, val := range v.persistent() {
+		var buf bytes.Buffer
+		if err := register(val); err != nil {
+			encodeErr[key] = err
+			continue
+		}
+		if err := gob.NewEncoder(&buf).Encode(&val); err != nil {
+			encodeErr[key] = err
+			continue
+		}
+		rec.Data[key] = buf.Bytes()
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&rec); err != nil {
+		return err
+	}
+	if err := s.Backend.Save(v.ID(), buf.Bytes(), s.ttl()); err != nil {
+		return err
+	}
+	if len(encodeErr) > 0 {
+		return encodeErr
+	}
+	return nil
+}
+
+// interface{} として変換できるよう、値の型を gob.Register で登録する
+func register(val interface{}) (err error) {
+	if val == nil {
+		return nil
+	}
+	// 同名の別の型が登録済みの場合、gob.Register は panic となる
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	gob.Register(val)
+	return nil
+}
+
+// Delete : 指定したリンクIDのデータを削除する
+func (s *BackendStore) Delete(id string) error {
+	if validID.MatchString(id) == false {
+		return fmt.Errorf("'%s' invalid id", id)
+	}
+	return s.Backend.Remove(id)
+}
+
+// Expire : 最終アクセスから ttl 以上経過したデータを削除する。ttl が TTL より短い場合は、TTL を使用する
+func (s *BackendStore) Expire(ttl time.Duration) error {
+	if ttl < s.ttl() {
+		ttl = s.ttl()
+	}
+	return s.Backend.Expire(ttl)
+}
+
+// FileBackend : ディレクトリ配下のファイルへデータを保存する Backend
+// 複数のインスタンスで同一のディレクトリを共有すれば、インスタンス間でデータを共有できる
+type FileBackend struct {
+	Directory string // データを保存するディレクトリ
+}
+
+// Load : ファイルからデータを読み込む
+func (f *FileBackend) Load(id string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(f.Directory, id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return buf, err
+}
+
+// Save : データをファイルへ保存する。書き込み途中のファイルを読み込まないよう、一時ファイルへ書き込んだ後に置き換える
+func (f *FileBackend) Save(id string, data []byte, ttl time.Duration) error {
+	if err := os.MkdirAll(f.Directory, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(f.Directory, "."+id)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(f.Directory, id))
+}
+
+// Remove : データを保存したファイルを削除する
+func (f *FileBackend) Remove(id string) error {
+	if err := os.Remove(filepath.Join(f.Directory, id)); err != nil && os.IsNotExist(err) == false {
+		return err
+	}
+	return nil
+}
+
+// Expire : 最終更新から ttl 以上経過したファイルを削除する
+func (f *FileBackend) Expire(ttl time.Duration) error {
+	files, err := ioutil.ReadDir(f.Directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	limit := time.Now().Add(-ttl)
+	for _, file := range files {
+		if file.IsDir() || file.ModTime().After(limit) {
+			continue
+		}
+		if err := f.Remove(file.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package basemux
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Streamer : 描画中にもタイムアウトを適用する Render
+// Main の復帰値が Streamer の場合、Stream は Main と同じタイムアウト時間内で処理される
+// StreamView、FileView は、ヘッダを出力した時点でタイムアウトを無効にし、送信をタイムアウトで中断しない
+// Stream が何も書き込まずにエラーを返却した場合は、Error がコールされる
+type Streamer interface {
+	Render
+	Stream(http.ResponseWriter, *http.Request) error
+}
+
+// StreamView : レスポンスボディを逐次書き込む Streamer
+// View と異なり、レスポンスボディ全体をメモリ上に保持しないため、大きなファイルの送信などに使用する
+type StreamView struct {
+	StatusCode    int                   // ステータスコード(未設定の場合は200)
+	ContentType   string                // Content-Type
+	ContentLength int64                 // Content-Length(0以下の場合は出力しない)
+	Header        http.Header           // 追加で出力するヘッダ
+	Body          func(io.Writer) error // レスポンスボディを書き込む関数
+}
+
+// Render : レスポンスボディを書き込む。エラーが発生した場合は、ログへ出力する
+func (v *StreamView) Render(w http.ResponseWriter, r *http.Request) {
+	if err := v.Stream(w, r); err != nil {
+		log.Println(err)
+	}
+}
+
+// Stream : レスポンスボディを書き込む。ヘッダは最初の書き込み時に出力され、以降はタイムアウトを無効にする
+func (v *StreamView) Stream(w http.ResponseWriter, r *http.Request) error {
+	sw := &streamWriter{w: &untimedWriter{ResponseWriter: w, r: r}, view: v}
+	if v.Body != nil {
+		if err := v.Body(sw); err != nil {
+			return err
+		}
+	}
+	// 何も書き込まれなかった場合も、ヘッダを出力する
+	sw.writeHeader()
+	return nil
+}
+
+// StreamView.Body に渡す、書き込み毎にフラッシュする io.Writer
+type streamWriter struct {
+	w       http.ResponseWriter
+	view    *StreamView
+	started bool
+}
+
+// ヘッダを出力する
+func (sw *streamWriter) writeHeader() {
+	if sw.started {
+		return
+	}
+	sw.started = true
+
+	header := sw.w.Header()
+	for k, v := range sw.view.Header {
+		header[k] = v
+	}
+	if sw.view.ContentType != "" {
+		header.Set("Content-Type", sw.view.ContentType)
+	}
+	if sw.view.ContentLength > 0 {
+		header.Set("Content-Length", strconv.FormatInt(sw.view.ContentLength, 10))
+	}
+	if sw.view.StatusCode == 0 {
+		sw.view.StatusCode = 200
+	}
+	sw.w.WriteHeader(sw.view.StatusCode)
+}
+
+// Write : レスポンスボディを書き込み、クライアントへフラッシュする
+func (sw *streamWriter) Write(b []byte) (int, error) {
+	sw.writeHeader()
+	n, err := sw.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	sw.Flush()
+	return n, nil
+}
+
+// Flush : 書き込んだ内容をクライアントへフラッシュする
+func (sw *streamWriter) Flush() {
+	sw.writeHeader()
+	if f, ok := sw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ヘッダの出力時に、リクエストのタイムアウトを無効にする http.ResponseWriter
+// 送信開始後は、大きなファイルや低速なクライアントへの送信を、タイムアウトで中断しない
+// タイムアウトを無効にしたリクエストは、Shutdown、Close のコール時、またはクライアントの切断時に中断する
+type untimedWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	started bool
+}
+
+// タイムアウトを無効にする
+func (uw *untimedWriter) start() {
+	if uw.started {
+		return
+	}
+	uw.started = true
+	DisableTimeout(uw.r)
+}
+
+// WriteHeader : タイムアウトを無効にし、ステータスコードを書き込む
+func (uw *untimedWriter) WriteHeader(code int) {
+	uw.start()
+	uw.ResponseWriter.WriteHeader(code)
+}
+
+// Write : タイムアウトを無効にし、レスポンスを書き込む
+func (uw *untimedWriter) Write(b []byte) (int, error) {
+	uw.start()
+	return uw.ResponseWriter.Write(b)
+}
+
+// Flush : 書き込んだ内容をクライアントへフラッシュする
+func (uw *untimedWriter) Flush() {
+	uw.start()
+	if f, ok := uw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
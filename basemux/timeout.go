@@ -0,0 +1,324 @@
+package basemux
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// タイムアウト後に、アクションからの書き込みを破棄する http.ResponseWriter
+type timeoutWriter struct {
+	w           http.ResponseWriter
+	mu          sync.Mutex
+	header      http.Header
+	wroteHeader bool
+	closed      bool
+}
+
+// timeoutWriter を生成する
+func newTimeoutWriter(w http.ResponseWriter) *timeoutWriter {
+	return &timeoutWriter{
+		w:      w,
+		header: make(http.Header),
+	}
+}
+
+// Header : アクション専用のヘッダを返却する。ヘッダは WriteHeader、または finish のコール時に反映される
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// Write : タイムアウト前であれば、レスポンスを書き込む
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	// タイムアウト後の書き込みは破棄する
+	if tw.closed {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.wroteHeader == false {
+		tw.writeHeader(http.StatusOK)
+	}
+	return tw.w.Write(b)
+}
+
+// WriteHeader : タイムアウト前であれば、ステータスコードを書き込む
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	// タイムアウト後、または書き込み済みの場合は何もしない
+	if tw.closed || tw.wroteHeader {
+		return
+	}
+	tw.writeHeader(code)
+}
+
+// アクション専用のヘッダを反映し、ステータスコードを書き込む
+func (tw *timeoutWriter) writeHeader(code int) {
+	tw.copyHeader()
+	tw.wroteHeader = true
+	tw.w.WriteHeader(code)
+}
+
+// アクション専用のヘッダを、元の http.ResponseWriter へ反映する
+func (tw *timeoutWriter) copyHeader() {
+	dst := tw.w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+}
+
+// Flush : タイムアウト前であれば、書き込んだ内容をクライアントへフラッシュする
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.closed {
+		return
+	}
+	if tw.wroteHeader == false {
+		tw.writeHeader(http.StatusOK)
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack : タイムアウト前で、レスポンスを書き込んでいなければ、接続を乗っ取る
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.closed {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	if tw.wroteHeader {
+		return nil, nil, fmt.Errorf("response already written")
+	}
+	h, ok := tw.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not implement http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	// 乗っ取り後は、レスポンスを書き込み済みとして扱う
+	tw.wroteHeader = true
+	return conn, rw, nil
+}
+
+// ステータスコードを書き込み済みか否かを返却する
+func (tw *timeoutWriter) written() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.wroteHeader
+}
+
+// タイムアウト前にアクションが完了した場合にコールし、ヘッダを反映したうえで以降の書き込みを破棄する
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.wroteHeader == false {
+		tw.copyHeader()
+	}
+	tw.closed = true
+}
+
+// タイムアウト時にコールし、以降の書き込みを破棄する
+func (tw *timeoutWriter) timeout() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.closed = true
+}
+
+// timeoutContext のキー
+type timeoutKey struct{}
+
+// 処理中にタイムアウト時間を変更可能なコンテキスト
+type timeoutContext struct {
+	context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	start    time.Time
+	timeout  int
+	timer    *time.Timer
+	timedout bool
+	expired  func()
+	done     <-chan struct{} // シャットダウン通知チャネル
+	disabled bool            // タイムアウトを無効にしたか否か
+}
+
+// timeoutContext を生成する。タイムアウト時には、コンテキストを終了する前に expired をコールする
+// done には、タイムアウトを無効にした場合に、コンテキストを終了するシャットダウン通知チャネルを指定する
+func newTimeoutContext(parent context.Context, timeout int, expired func(), done <-chan struct{}) *timeoutContext {
+	ctx, cancel := context.WithCancel(parent)
+	tc := &timeoutContext{
+		Context: ctx,
+		cancel:  cancel,
+		start:   time.Now(),
+		timeout: timeout,
+		expired: expired,
+		done:    done,
+	}
+	tc.timer = time.AfterFunc(time.Duration(timeout)*time.Second, tc.expire)
+	return tc
+}
+
+// タイムアウト時にコールされ、コンテキストを終了する
+func (tc *timeoutContext) expire() {
+	tc.expired()
+	tc.mu.Lock()
+	tc.timedout = true
+	tc.mu.Unlock()
+	tc.cancel()
+}
+
+// Deadline : タイムアウトする時刻を返却する
+func (tc *timeoutContext) Deadline() (time.Time, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.disabled {
+		return tc.Context.Deadline()
+	}
+	return tc.start.Add(time.Duration(tc.timeout) * time.Second), true
+}
+
+// Err : タイムアウトした場合は context.DeadlineExceeded を返却する
+func (tc *timeoutContext) Err() error {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.timedout {
+		return context.DeadlineExceeded
+	}
+	return tc.Context.Err()
+}
+
+// Value : timeoutKey が指定された場合は、自身を返却する
+func (tc *timeoutContext) Value(key interface{}) interface{} {
+	if _, ok := key.(timeoutKey); ok {
+		return tc
+	}
+	return tc.Context.Value(key)
+}
+
+// タイムアウト時間を変更する。タイムアウト時間は、処理開始時刻からの秒数とする
+func (tc *timeoutContext) reset(timeout int) bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	// タイムアウト済みの場合は変更しない
+	if tc.timedout || tc.timer.Stop() == false {
+		return false
+	}
+	tc.timeout = timeout
+	tc.timer.Reset(time.Until(tc.start.Add(time.Duration(timeout) * time.Second)))
+	return true
+}
+
+// タイムアウトを無効にする。以降は、シャットダウン時、またはクライアントの切断時にコンテキストを終了する
+func (tc *timeoutContext) disable() bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	// タイムアウト済みの場合は変更しない
+	if tc.timedout || tc.timer.Stop() == false {
+		return false
+	}
+	tc.disabled = true
+	go func() {
+		select {
+		case <-tc.done:
+			tc.cancel()
+		case <-tc.Context.Done():
+		}
+	}()
+	return true
+}
+
+// 設定されているタイムアウト時間(秒単位)を返却する
+func (tc *timeoutContext) limit() int {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.timeout
+}
+
+// コンテキストを終了し、タイマーを停止する
+func (tc *timeoutContext) stop() {
+	tc.timer.Stop()
+	tc.cancel()
+}
+
+// SetTimeout : 処理中のリクエストのタイムアウト時間(秒単位)を変更する。タイムアウト時間は、処理開始時刻からの秒数とする
+// タイムアウト済みの場合や、basemux.Mux が処理していないリクエストの場合は false を返却する
+func SetTimeout(r *http.Request, timeout int) bool {
+	if timeout <= 0 {
+		return false
+	}
+	tc, ok := r.Context().Value(timeoutKey{}).(*timeoutContext)
+	if !ok {
+		return false
+	}
+	return tc.reset(timeout)
+}
+
+// DisableTimeout : 処理中のリクエストのタイムアウトを無効にする。Server-Sent Events など、接続を維持し続ける場合に使用する
+// タイムアウトを無効にしたリクエストのコンテキストは、Shutdown、Close のコール時、またはクライアントの切断時に終了する
+// タイムアウト済みの場合や、basemux.Mux が処理していないリクエストの場合は false を返却する
+func DisableTimeout(r *http.Request) bool {
+	tc, ok := r.Context().Value(timeoutKey{}).(*timeoutContext)
+	if !ok {
+		return false
+	}
+	return tc.disable()
+}
+
+// RouteTimeout : パス毎にタイムアウト時間を設定する構造体
+type RouteTimeout struct {
+	Path    string // 設定対象のパス(前方一致)
+	Timeout int    // タイムアウト時間(秒単位)
+}
+
+// パスを整形する
+func (rt *RouteTimeout) init() error {
+	// タイムアウト時間が未設定の場合はエラーとする
+	if rt.Timeout <= 0 {
+		return fmt.Errorf("'%s' route Timeout must be greater than 0", rt.Path)
+	}
+	// /path/to/url => /path/to/url/ へ変換する
+	rt.Path = strings.TrimRight(rt.Path, "/") + "/"
+	return nil
+}
+
+// 指定されたパスに該当するタイムアウト時間のうち、最も長いパスのタイムアウト時間を返却する
+func (mux *Mux) timeout(path string) int {
+	path = strings.TrimRight(path, "/") + "/"
+
+	var result *RouteTimeout
+	for _, v := range mux.RouteTimeouts {
+		if strings.Index(path, v.Path) != 0 {
+			continue
+		}
+		if result == nil || len(result.Path) < len(v.Path) {
+			result = v
+		}
+	}
+	// 該当するパスがない場合は、全体のタイムアウト時間を返却する
+	if result == nil {
+		return mux.Timeout
+	}
+	return result.Timeout
+}
+
+// PathTimeout : 指定されたパスに適用されるタイムアウト時間(秒単位)を返却する
+func (mux *Mux) PathTimeout(path string) int {
+	return mux.timeout(path)
+}
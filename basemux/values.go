@@ -1,18 +1,44 @@
 package basemux
 
 import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
 	"fmt"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 )
 
+// リンクIDに付与する署名の長さ
+const signSize = 32
+
 // Values : キーと値でデータを管理する構造体
 type Values struct {
-	mu     sync.Mutex
-	id     string
-	access int64
-	data   map[string]interface{}
-	old    Referer
+	mu       sync.Mutex
+	id       string
+	access   int64
+	data     map[string]interface{}
+	local    map[string]bool // SetLocal でセットした値のキー
+	modified bool            // Set で値をセットしたか否か
+	old      Referer
+	key      []byte        // リンクIDの署名鍵
+	owner    func() string // リンクIDを紐付けるクライアントの識別子を返却する関数
+}
+
+// NewValues : 指定したリンクIDとデータで Values を作成する。old には、Old 関数で使用するストアを指定する
+func NewValues(id string, data map[string]interface{}, old Referer) *Values {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	return &Values{
+		id:     id,
+		access: time.Now().Unix(),
+		data:   data,
+		old:    old,
+	}
 }
 
 // ID : リンクIDを返却する
@@ -24,6 +50,19 @@ func (v *Values) ID() string {
 func (v *Values) Set(key string, val interface{}) {
 	v.mu.Lock()
 	defer v.mu.Unlock()
+	delete(v.local, key)
+	v.data[key] = val
+	v.modified = true
+}
+
+// SetLocal : リクエスト内でのみ使用する値をセットする。BackendStore には保存しない
+func (v *Values) SetLocal(key string, val interface{}) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.local == nil {
+		v.local = make(map[string]bool)
+	}
+	v.local[key] = true
 	v.data[key] = val
 }
 
@@ -43,8 +82,91 @@ func (v *Values) Val(key string) interface{} {
 	return val
 }
 
-// Old : 過去にリファラに登録されていた情報を、指定したIDで取得する
-func (v *Values) Old(id string) *Values {
+// Data : セットした値をすべて複製して返却する
+func (v *Values) Data() map[string]interface{} {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var result = make(map[string]interface{}, len(v.data))
+	for key, val := range v.data {
+		result[key] = val
+	}
+	return result
+}
+
+// Set で値をセットしたか否かを返却する
+func (v *Values) changed() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.modified
+}
+
+// SetLocal でセットした値を除き、セットした値を複製して返却する
+func (v *Values) persistent() map[string]interface{} {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var result = make(map[string]interface{}, len(v.data))
+	for key, val := range v.data {
+		if v.local[key] == false {
+			result[key] = val
+		}
+	}
+	return result
+}
+
+// LinkOwner : リンクIDを紐付けるクライアントの識別子を返却する関数
+type LinkOwner func(r *http.Request, v *Values) string
+
+// RemoteAddr : クライアントのIPアドレスを、リンクIDを紐付けるクライアントの識別子として返却する
+func RemoteAddr(r *http.Request, v *Values) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// 署名鍵と、リンクIDを紐付けるクライアントの識別子を返却する関数を設定する
+func (v *Values) bind(key []byte, owner func() string) {
+	v.key = key
+	v.owner = owner
+}
+
+// リンクIDとクライアントの識別子から署名を生成する
+func (v *Values) sign(id string) string {
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write([]byte(id + "|" + v.owner()))
+	return fmt.Sprintf("%X", mac.Sum(nil))[:signSize]
+}
+
+// LinkID : クライアントへ渡すリンクIDを返却する。署名鍵が設定されている場合は、クライアントに紐付けた署名を付与する
+func (v *Values) LinkID() string {
+	if v.key == nil {
+		return v.id
+	}
+	return v.id + v.sign(v.id)
+}
+
+// Old : 過去にリファラに登録されていた情報を、LinkID で取得したリンクIDで取得する
+// 署名鍵が設定されている場合、署名が不正なリンクIDや、別のクライアントに紐付いたリンクIDの場合は nil を返却する
+func (v *Values) Old(linkid string) *Values {
+	if v.key == nil {
+		return v.lookup(linkid)
+	}
+	if len(linkid) <= signSize {
+		return nil
+	}
+	id := linkid[:len(linkid)-signSize]
+	if subtle.ConstantTimeCompare([]byte(linkid[len(id):]), []byte(v.sign(id))) != 1 {
+		return nil
+	}
+	return v.lookup(id)
+}
+
+// リンクIDに該当する Values を返却する。処理中のリクエストの Values は、保存前のため自身を返却する
+func (v *Values) lookup(id string) *Values {
+	if id == v.id {
+		return v
+	}
 	return v.old.Get(id)
 }
 
@@ -63,3 +185,19 @@ type ResponseWriter struct {
 	http.ResponseWriter
 	*Values
 }
+
+// Flush : 書き込んだ内容をクライアントへフラッシュする
+func (w *ResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack : 接続を乗っ取る。WebSocket などで使用する
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not implement http.Hijacker")
+	}
+	return h.Hijack()
+}
@@ -1,20 +1,28 @@
 package mux
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"runtime"
 	"strings"
 
 	"github.com/ochipin/locale"
 	"github.com/ochipin/logger/errorlog"
+	"github.com/ochipin/mux/session"
+	"github.com/ochipin/mux/websocket"
 	"github.com/ochipin/uploadfile"
 )
 
 // PrePost : アクション関数の事前、事後にコールする関数を管理する構造体
 type PrePost struct {
-	begins  []func() Result
-	commits []func() Result
+	begins   []func() Result
+	commits  []func() Result
+	timeouts map[string]int
+	exempts  map[string]bool
+	formats  map[string]string
+	paths    map[string]bool
 }
 
 // AddBeginFunc : 事前関数の登録
@@ -27,6 +35,86 @@ func (prepost *PrePost) AddCommitFunc(fn func() Result) {
 	prepost.commits = append(prepost.commits, fn)
 }
 
+// SetTimeout : アクションのタイムアウト時間(秒単位)を設定する。アクション名を指定しない場合は、全アクションが対象となる
+func (prepost *PrePost) SetTimeout(timeout int, actions ...string) {
+	if prepost.timeouts == nil {
+		prepost.timeouts = make(map[string]int)
+	}
+	if len(actions) == 0 {
+		prepost.timeouts["*"] = timeout
+	}
+	for _, name := range actions {
+		prepost.timeouts[name] = timeout
+	}
+}
+
+// 指定したアクションのタイムアウト時間を取得する。未設定の場合は 0 を返却する
+func (prepost *PrePost) timeout(actname string) int {
+	if timeout, ok := prepost.timeouts[actname]; ok {
+		return timeout
+	}
+	return prepost.timeouts["*"]
+}
+
+// SkipCSRF : CSRFトークンの検証を行わないアクションを設定する。アクション名を指定しない場合は、全アクションが対象となる
+func (prepost *PrePost) SkipCSRF(actions ...string) {
+	if prepost.exempts == nil {
+		prepost.exempts = make(map[string]bool)
+	}
+	if len(actions) == 0 {
+		prepost.exempts["*"] = true
+	}
+	for _, name := range actions {
+		prepost.exempts[name] = true
+	}
+}
+
+// 指定したアクションが、CSRFトークンの検証を行わないアクションか否かを判定する
+func (prepost *PrePost) csrfExempt(actname string) bool {
+	return prepost.exempts[actname] || prepost.exempts["*"]
+}
+
+// PathFormat : クエリパスの拡張子(ex: /users/1.json)で形式を指定できるアクションを設定する。アクション名を指定しない場合は、全アクションが対象となる
+// 設定したアクションは、Negotiate で形式を選択して出力すること。設定していないアクションへの拡張子付きのアクセスは 404 となる
+func (prepost *PrePost) PathFormat(actions ...string) {
+	if prepost.paths == nil {
+		prepost.paths = make(map[string]bool)
+	}
+	if len(actions) == 0 {
+		prepost.paths["*"] = true
+	}
+	for _, name := range actions {
+		prepost.paths[name] = true
+	}
+}
+
+// 指定したアクションが、クエリパスの拡張子で形式を指定できるアクションか否かを判定する
+func (prepost *PrePost) pathFormat(actname string) bool {
+	return prepost.paths[actname] || prepost.paths["*"]
+}
+
+// SetErrorFormat : エラー発生時に出力する形式(html, json, xml)を設定する。アクション名を指定しない場合は、全アクションが対象となる
+// json/xml の場合は、Accept ヘッダに関わらず RFC 7807 形式(application/problem+json, application/problem+xml)で出力する
+func (prepost *PrePost) SetErrorFormat(format string, actions ...string) {
+	if prepost.formats == nil {
+		prepost.formats = make(map[string]string)
+	}
+	if len(actions) == 0 {
+		prepost.formats["*"] = format
+	}
+	for _, name := range actions {
+		prepost.formats[name] = format
+	}
+}
+
+// 指定したアクションのエラー発生時の形式を取得する。未設定の場合は空文字列を返却する
+func (prepost *PrePost) errorFormat(actname string) string {
+	if format, ok := prepost.formats[actname]; ok {
+		return format
+	}
+	return prepost.formats["*"]
+}
+
 // Result : アクションの復帰値
 type Result interface {
 	pointer()
@@ -43,6 +131,9 @@ type Controller struct {
 	files       *uploadfile.File
 	contentlist map[string]string
 	locale      locale.Data
+	session     func() *session.Session
+	upgrader    *websocket.Upgrader
+	reverse     func(string, ...interface{}) (string, error)
 }
 
 // PrePostRegister : アクション実行前の事前、事後実行関数を登録する初期化関数
@@ -53,6 +144,16 @@ func (c *Controller) Req() *http.Request {
 	return c.r
 }
 
+// Context : リクエストのコンテキストを返却する。タイムアウト時には Done が通知されるため、時間のかかる処理ではこれを確認して処理を中断する
+func (c *Controller) Context() context.Context {
+	return c.r.Context()
+}
+
+// Session : クッキーセッションを返却する。セッションはアクセス時にクッキーから復元され、変更した場合はレスポンス時に保存される
+func (c *Controller) Session() *session.Session {
+	return c.session()
+}
+
 // Form : 入力フォームから得た情報を返却する
 func (c *Controller) Form() FormValues {
 	return FormValues(c.r.PostForm)
@@ -95,6 +196,69 @@ func (c *Controller) Redirect(i ...interface{}) *Redirect {
 	}
 }
 
+// RedirectTo : コントローラ名、アクション名("コントローラ名.アクション名")とパラメータから生成した URL へ、302 リダイレクトを行う
+// URL を生成できない場合は、ReverseError となる
+// ex) c.RedirectTo("Example.World", 1) => /baseurl/world/1
+func (c *Controller) RedirectTo(name string, args ...interface{}) *Redirect {
+	path, err := c.reverse(name, args...)
+	return &Redirect{
+		path:       path,
+		statuscode: 302,
+		err:        err,
+	}
+}
+
+// Stream : fn でレスポンスボディを逐次書き込む。書き込んだ内容は、書き込み毎にクライアントへフラッシュされる
+// fn が何も書き込まずにエラーを返却した場合はエラー画面を表示し、書き込み途中のエラーはログへ出力する
+func (c *Controller) Stream(content string, fn func(io.Writer) error) *Stream {
+	return &Stream{
+		content:    content,
+		statuscode: 200,
+		header:     make(http.Header),
+		fn:         fn,
+	}
+}
+
+// EventStream : fn で Server-Sent Events を送信する。送信中はタイムアウトしないため、fn は EventWriter.Context の終了を検知して復帰すること
+// EventWriter.Context は、クライアントの切断時、またはシャットダウン時に終了する
+func (c *Controller) EventStream(fn func(*EventWriter) error) *EventStream {
+	return &EventStream{
+		header: make(http.Header),
+		fn:     fn,
+	}
+}
+
+// WebSocket : ハンドシェイクを行い、fn で WebSocket の通信を行う。通信中はタイムアウトしない
+// シャットダウン時は、接続が切断されるため、fn はメッセージの受信エラーを検知して復帰すること
+func (c *Controller) WebSocket(fn func(*websocket.Conn) error) *WebSocket {
+	return &WebSocket{
+		upgrader: *c.upgrader,
+		fn:       fn,
+	}
+}
+
+// SendFile : reader から読み込んだ内容を、name をファイル名として送信する。reader が io.Closer の場合は、送信後にクローズする
+func (c *Controller) SendFile(name string, reader io.Reader) *SendFile {
+	return &SendFile{
+		name:    name,
+		reader:  reader,
+		content: c.contenttype(name),
+		size:    readerSize(reader),
+	}
+}
+
+// ファイル名の拡張子から、Content-Type を判定する
+func (c *Controller) contenttype(name string) string {
+	if idx := strings.LastIndex(name, "."); idx != -1 {
+		// Content-Typeリストに拡張子が登録されているか確認する
+		if v, ok := c.contentlist[name[idx:]]; ok {
+			return v
+		}
+	}
+	// Content-Typeリストに存在しない場合は、バイナリとして扱う
+	return "application/octet-stream"
+}
+
 // BasicAuth : ベーシック認証を実装する
 func (c *Controller) BasicAuth() (string, string, bool) {
 	return c.r.BasicAuth()
@@ -189,20 +353,8 @@ type Serve struct {
 func (c *Serve) File(name string) Result {
 	// ex) name => /assets/stylesheets/controllers/index.css
 	var path = name
-	var content string
-
-	// 拡張子が、Content-Typeリストに登録されている拡張子の場合
-	if idx := strings.LastIndex(name, "."); idx != -1 {
-		ext := name[idx:]
-		// Content-Typeリストに拡張子が登録されているか確認する
-		if v, ok := c.contentlist[ext]; ok {
-			content = v
-		}
-	}
-	// Content-Typeリストに存在しない場合は、バイナリとして扱う
-	if content == "" {
-		content = "application/octet-stream"
-	}
+	// 拡張子に応じた Content-Type を取得する
+	var content = c.contenttype(name)
 
 	// 静的ファイル情報を返却する
 	return &AssetsTemplate{
@@ -0,0 +1,134 @@
+package mux
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ochipin/mux/session"
+)
+
+const (
+	csrfKey = "_csrf" // CSRFトークンを保存するセッションのキー名
+	linkKey = "_link" // リンクIDを紐付ける識別子を保存するセッションのキー名
+)
+
+// CSRF : CSRF対策の設定。Mux.CSRF を設定した場合のみ有効となり、Session.Keys の設定が必須となる
+type CSRF struct {
+	Disable   bool     // CSRF対策を無効にするか否か
+	FieldName string   // トークンを送信するパラメータ名(未設定の場合は "_csrf")
+	Header    string   // トークンを送信するヘッダ名(未設定の場合は "X-CSRF-Token")
+	Exempts   []string // 検証を行わないパス(前方一致)。BaseURL を除いたパスで指定する
+}
+
+// 設定値を検証し、未設定の値にデフォルト値を適用する
+func (csrf *CSRF) init() {
+	if csrf.FieldName == "" {
+		csrf.FieldName = "_csrf"
+	}
+	if csrf.Header == "" {
+		csrf.Header = "X-CSRF-Token"
+	}
+	// /path/to/url => /path/to/url/ へ変換する
+	for i, path := range csrf.Exempts {
+		csrf.Exempts[i] = strings.TrimRight(path, "/") + "/"
+	}
+}
+
+// 指定されたパスが、検証を行わないパスか否かを判定する
+func (csrf *CSRF) exempt(path string) bool {
+	path = strings.TrimRight(path, "/") + "/"
+	for _, v := range csrf.Exempts {
+		if strings.Index(path, v) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// 安全なリクエストメソッドか否かを判定する
+// _method で上書きされたリクエストは、上書き後のメソッドにかかわらず POST で送信されているため、安全なメソッドとはみなさない
+func safeMethod(r *http.Request, methodname string) bool {
+	if len(r.PostForm[methodname]) != 0 && r.PostForm[methodname][0] != "" {
+		return false
+	}
+	switch strings.ToUpper(r.Method) {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// セッションに保存されている CSRF トークンを返却する。トークンが未生成の場合は生成し、セッションへ保存する
+func csrfToken(sess *session.Session) string {
+	return sessionToken(sess, csrfKey)
+}
+
+// セッションに保存されているランダムな文字列を返却する。未生成の場合は生成し、セッションへ保存する
+func sessionToken(sess *session.Session, key string) string {
+	if token := sess.Get(key); token != "" {
+		return token
+	}
+	buf := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		panic(err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	sess.Set(key, token)
+	return token
+}
+
+// VerifyCSRF : 安全ではないリクエストメソッドの場合、送信された CSRF トークンを検証する
+// トークンは、パラメータ、またはヘッダから取得する。検証に失敗した場合は CSRFError を返却する
+func (mux *Mux) VerifyCSRF(r *http.Request, v *Values) error {
+	if mux.CSRF.Disable || safeMethod(r, mux.MethodName) {
+		return nil
+	}
+	// 検証を行わないパスの場合は、検証しない
+	path := r.URL.Path
+	if mux.BaseURL != "/" {
+		path = strings.TrimPrefix(path, mux.BaseURL)
+	}
+	if mux.CSRF.exempt(path) {
+		return nil
+	}
+
+	// 送信されたトークンを取得する
+	token := r.Header.Get(mux.CSRF.Header)
+	if token == "" {
+		token = r.PostFormValue(mux.CSRF.FieldName)
+	}
+	if token == "" {
+		return &CSRFError{
+			Message:    "CSRF token missing: " + r.Method + " " + r.URL.Path,
+			Method:     r.Method,
+			StatusCode: 403,
+		}
+	}
+
+	// セッションに保存されているトークンと比較する
+	expect := mux.LoadSession(r, v).Get(csrfKey)
+	if expect == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expect)) != 1 {
+		return &CSRFError{
+			Message:    "CSRF token mismatch: " + r.Method + " " + r.URL.Path,
+			Method:     r.Method,
+			StatusCode: 403,
+		}
+	}
+	return nil
+}
+
+// アクションの PrePostRegister で、CSRFトークンの検証を行わないよう設定されているか否かを判定する
+// act には、ExecAction で取得したアクションを指定する。該当するアクションがない(nil)場合は、検証を行う
+func (mux *Mux) csrfExempt(r *http.Request, act *preparedAction) bool {
+	if mux.CSRF.Disable || safeMethod(r, mux.MethodName) {
+		return true
+	}
+	if act == nil {
+		return false
+	}
+	return act.prepost.csrfExempt(act.actname)
+}
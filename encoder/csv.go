@@ -0,0 +1,76 @@
+package encoder
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"reflect"
+)
+
+// CSV : 構造体のスライスを、CSV形式へ変換する。1行目はヘッダとなり、ヘッダ名は csv タグ、json タグ、フィールド名の順に決定する
+// [][]string の場合は、そのまま各行として出力する
+func CSV(v interface{}) ([]byte, error) {
+	rv := indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("csv: data must be a slice of structs, not %s", reflect.TypeOf(v))
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	// [][]string の場合は、そのまま出力する
+	if rows, ok := rv.Interface().([][]string); ok {
+		if err := w.WriteAll(rows); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	t := rv.Type().Elem()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("csv: data must be a slice of structs, not %s", rv.Type())
+	}
+
+	// ヘッダを出力する
+	list := fields(t, "csv")
+	header := make([]string, len(list))
+	for i, f := range list {
+		header[i] = f.name
+	}
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	// 各行を出力する
+	for i := 0; i < rv.Len(); i++ {
+		row := make([]string, len(list))
+		elem := indirect(rv.Index(i))
+		if elem.IsValid() {
+			for j, f := range list {
+				value := fieldValue(elem, f.index)
+				if value.IsValid() {
+					value = indirect(value)
+				}
+				cell, ok, err := scalar(value)
+				if err != nil {
+					return nil, fmt.Errorf("csv: [%d].%s: %s", i, f.name, err)
+				}
+				if !ok {
+					return nil, fmt.Errorf("csv: %s", &UnsupportedTypeError{Path: fmt.Sprintf("[%d].%s", i, f.name), Type: value.Type()})
+				}
+				row[j] = cell
+			}
+		}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,218 @@
+package encoder
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Encoder : Data 関数で登録されたデータを、指定の形式へ変換する構造体
+type Encoder struct {
+	ContentType string                              // 出力する Content-Type
+	Encode      func(v interface{}) ([]byte, error) // データを変換する関数
+}
+
+// Defaults : 標準で提供する形式(csv, yaml, msgpack, ndjson)を返却する
+func Defaults() map[string]*Encoder {
+	return map[string]*Encoder{
+		"csv":     {ContentType: "text/csv; charset=UTF-8", Encode: CSV},
+		"yaml":    {ContentType: "application/yaml; charset=UTF-8", Encode: YAML},
+		"msgpack": {ContentType: "application/x-msgpack", Encode: MessagePack},
+		"ndjson":  {ContentType: "application/x-ndjson; charset=UTF-8", Encode: NDJSON},
+	}
+}
+
+// UnsupportedTypeError : 変換できない型のデータが含まれていた場合のエラー
+type UnsupportedTypeError struct {
+	Path string       // エラーが発生したデータの位置(ex: [0].Name)
+	Type reflect.Type // 変換できない型
+}
+
+func (err *UnsupportedTypeError) Error() string {
+	if err.Path == "" {
+		return fmt.Sprintf("unsupported type: %s", err.Type)
+	}
+	return fmt.Sprintf("%s: unsupported type: %s", err.Path, err.Type)
+}
+
+// 構造体のフィールド情報
+type field struct {
+	name      string // 出力するキー名
+	index     []int  // フィールドの位置
+	omitempty bool   // 空の値を出力しないか否か
+}
+
+var textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// 構造体の出力対象のフィールドを取得する。キー名は tag で指定したタグ、json タグ、フィールド名の順に決定する
+func fields(t reflect.Type, tag string) []field {
+	var result []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		// 非公開のフィールドは対象外とする
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		value, ok := f.Tag.Lookup(tag)
+		if !ok {
+			value = f.Tag.Get("json")
+		}
+		if value == "-" {
+			continue
+		}
+		opts := strings.Split(value, ",")
+		// タグのない埋め込み構造体は、フィールドを展開する
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && opts[0] == "" && ft.Kind() == reflect.Struct {
+			for _, sub := range fields(ft, tag) {
+				sub.index = append([]int{i}, sub.index...)
+				result = append(result, sub)
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		name := opts[0]
+		if name == "" {
+			name = f.Name
+		}
+		var omitempty bool
+		for _, opt := range opts[1:] {
+			if opt == "omitempty" {
+				omitempty = true
+			}
+		}
+		result = append(result, field{name: name, index: []int{i}, omitempty: omitempty})
+	}
+	return result
+}
+
+// フィールドの値を取得する。埋め込み構造体が nil ポインタの場合は、無効な値を返却する
+func fieldValue(v reflect.Value, index []int) reflect.Value {
+	for i, idx := range index {
+		if i > 0 {
+			if v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					return reflect.Value{}
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(idx)
+	}
+	return v
+}
+
+// ポインタ、インタフェースから値を取り出す。nil の場合は無効な値を返却する
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		// encoding.TextMarshaler を実装したポインタは、そのまま使用する
+		if v.Kind() == reflect.Ptr && !v.IsNil() && v.Type().Implements(textMarshaler) {
+			return v
+		}
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// 空の値か否かを判定する
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Invalid:
+		return true
+	}
+	return false
+}
+
+// encoding.TextMarshaler を実装している場合は、変換した文字列を返却する
+func marshalText(v reflect.Value) (string, bool, error) {
+	if !v.IsValid() || !v.Type().Implements(textMarshaler) {
+		return "", false, nil
+	}
+	buf, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+	return string(buf), true, err
+}
+
+// スカラ値を文字列へ変換する。スカラ値ではない場合は false を返却する
+func scalar(v reflect.Value) (string, bool, error) {
+	if s, ok, err := marshalText(v); ok {
+		return s, true, err
+	}
+	switch v.Kind() {
+	case reflect.Invalid:
+		return "", true, nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), true, nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32), true, nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), true, nil
+	case reflect.String:
+		return v.String(), true, nil
+	}
+	return "", false, nil
+}
+
+// マップのキーを、文字列の昇順で返却する
+func sortedKeys(v reflect.Value) ([]reflect.Value, []string, error) {
+	keys := v.MapKeys()
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		name, ok, err := scalar(indirect(key))
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			return nil, nil, &UnsupportedTypeError{Type: key.Type()}
+		}
+		names[i] = name
+	}
+	sort.Sort(&keySorter{keys, names})
+	return keys, names, nil
+}
+
+type keySorter struct {
+	keys  []reflect.Value
+	names []string
+}
+
+func (s *keySorter) Len() int           { return len(s.keys) }
+func (s *keySorter) Less(i, j int) bool { return s.names[i] < s.names[j] }
+func (s *keySorter) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.names[i], s.names[j] = s.names[j], s.names[i]
+}
+
+// エラー発生位置を連結する
+func join(path, name string) string {
+	if path == "" || strings.HasPrefix(name, "[") {
+		return path + name
+	}
+	return path + "." + name
+}
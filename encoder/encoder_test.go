@@ -0,0 +1,134 @@
+package encoder
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+type Base struct {
+	ID int `csv:"id" json:"id"`
+}
+
+type Row struct {
+	Base
+	Name    string     `csv:"name" json:"name"`
+	Score   float64    `json:"score"`
+	Tags    []string   `csv:"-" json:"tags,omitempty"`
+	Created *time.Time `csv:"created" json:"created,omitempty"`
+	secret  string
+}
+
+func rows() []*Row {
+	created := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []*Row{
+		{Base: Base{1}, Name: "foo, bar", Score: 1.5, Tags: []string{"a", "b"}, Created: &created},
+		{Base: Base{2}, Name: "baz", Score: 2},
+	}
+}
+
+func Test_CSV(t *testing.T) {
+	buf, err := CSV(rows())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "id,name,score,created\n1,\"foo, bar\",1.5,2018-01-02T03:04:05Z\n2,baz,2,\n" {
+		t.Fatal(string(buf))
+	}
+	// [][]string はそのまま出力する
+	if buf, err := CSV([][]string{{"a", "b"}, {"1", "2"}}); err != nil || string(buf) != "a,b\n1,2\n" {
+		t.Fatal(string(buf), err)
+	}
+	// 構造体のスライス以外はエラーとする
+	if _, err := CSV(map[string]string{}); err == nil {
+		t.Fatal("ERROR")
+	}
+	// 変換できないフィールドは、位置を含めてエラーとする
+	if _, err := CSV([]struct{ Values []int }{{}}); err == nil || err.Error() != "csv: [0].Values: unsupported type: []int" {
+		t.Fatal(err)
+	}
+}
+
+func Test_NDJSON(t *testing.T) {
+	buf, err := NDJSON(rows())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != `{"id":1,"name":"foo, bar","score":1.5,"tags":["a","b"],"created":"2018-01-02T03:04:05Z"}`+"\n"+`{"id":2,"name":"baz","score":2}`+"\n" {
+		t.Fatal(string(buf))
+	}
+	if buf, err := NDJSON(map[string]int{"a": 1}); err != nil || string(buf) != "{\"a\":1}\n" {
+		t.Fatal(string(buf), err)
+	}
+	if _, err := NDJSON([]interface{}{1, func() {}}); err == nil {
+		t.Fatal("ERROR")
+	}
+}
+
+func Test_YAML(t *testing.T) {
+	buf, err := YAML(map[string]interface{}{
+		"rows":  rows(),
+		"empty": []int{},
+		"text":  "true",
+		"nil":   nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `empty: []
+nil: null
+rows:
+  -
+    id: 1
+    name: foo, bar
+    score: 1.5
+    tags:
+      - a
+      - b
+    created: 2018-01-02T03:04:05Z
+  -
+    id: 2
+    name: baz
+    score: 2
+text: "true"
+`
+	if string(buf) != expect {
+		t.Fatal(string(buf))
+	}
+	if buf, err := YAML("a: b"); err != nil || string(buf) != "\"a: b\"\n" {
+		t.Fatal(string(buf), err)
+	}
+	if _, err := YAML(map[string]interface{}{"a": []interface{}{make(chan int)}}); err == nil || err.Error() != "yaml: a[0]: unsupported type: chan int" {
+		t.Fatal(err)
+	}
+}
+
+func Test_MessagePack(t *testing.T) {
+	buf, err := MessagePack(map[string]interface{}{
+		"a": []interface{}{nil, true, -1, 200, -200, 1.5, "x"},
+		"b": []byte{1, 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []byte{
+		0x82,
+		0xa1, 'a', 0x97, 0xc0, 0xc3, 0xff, 0xcc, 200, 0xd1, 0xff, 0x38,
+		0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0, 0xa1, 'x',
+		0xa1, 'b', 0xc4, 2, 1, 2,
+	}
+	if !bytes.Equal(buf, expect) {
+		t.Fatalf("% x", buf)
+	}
+	// 構造体はマップとして出力する
+	buf, err = MessagePack(struct {
+		Name string `msgpack:"name"`
+		Skip string `msgpack:"skip,omitempty"`
+	}{Name: "n"})
+	if err != nil || !bytes.Equal(buf, []byte{0x81, 0xa4, 'n', 'a', 'm', 'e', 0xa1, 'n'}) {
+		t.Fatalf("% x %v", buf, err)
+	}
+	if _, err := MessagePack(complex(1, 2)); err == nil {
+		t.Fatal("ERROR")
+	}
+}
@@ -0,0 +1,165 @@
+package encoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// MessagePack : データをMessagePack形式へ変換する。構造体はマップとして出力し、キー名は msgpack タグ、json タグ、フィールド名の順に決定する
+func MessagePack(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := msgpackValue(&buf, reflect.ValueOf(v), ""); err != nil {
+		return nil, fmt.Errorf("msgpack: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// 値を出力する
+func msgpackValue(buf *bytes.Buffer, v reflect.Value, path string) error {
+	v = indirect(v)
+	if s, ok, err := marshalText(v); ok {
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		msgpackString(buf, s)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Invalid:
+		buf.WriteByte(0xc0)
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		msgpackInt(buf, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		msgpackUint(buf, v.Uint())
+	case reflect.Float32:
+		buf.WriteByte(0xca)
+		binary.Write(buf, binary.BigEndian, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v.Float()))
+	case reflect.String:
+		msgpackString(buf, v.String())
+	case reflect.Slice, reflect.Array:
+		// []byte はバイナリとして出力する
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			msgpackHeader(buf, len(b), 0, 0xc4, 0xc5, 0xc6)
+			buf.Write(b)
+			return nil
+		}
+		msgpackHeader(buf, v.Len(), 0x90, 0, 0xdc, 0xdd)
+		for i := 0; i < v.Len(); i++ {
+			if err := msgpackValue(buf, v.Index(i), join(path, fmt.Sprintf("[%d]", i))); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		keys, names, err := sortedKeys(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		msgpackHeader(buf, len(keys), 0x80, 0, 0xde, 0xdf)
+		for i, key := range keys {
+			if err := msgpackValue(buf, key, path); err != nil {
+				return err
+			}
+			if err := msgpackValue(buf, v.MapIndex(key), join(path, names[i])); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		var list []field
+		for _, f := range fields(v.Type(), "msgpack") {
+			if f.omitempty && isEmpty(fieldValue(v, f.index)) {
+				continue
+			}
+			list = append(list, f)
+		}
+		msgpackHeader(buf, len(list), 0x80, 0, 0xde, 0xdf)
+		for _, f := range list {
+			msgpackString(buf, f.name)
+			if err := msgpackValue(buf, fieldValue(v, f.index), join(path, f.name)); err != nil {
+				return err
+			}
+		}
+	default:
+		return &UnsupportedTypeError{Path: path, Type: v.Type()}
+	}
+	return nil
+}
+
+// 符号付き整数を、最小のサイズで出力する
+func msgpackInt(buf *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0:
+		msgpackUint(buf, uint64(n))
+	case n >= -32:
+		buf.WriteByte(byte(n))
+	case n >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(n))
+	case n >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(n))
+	case n >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(n))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+// 符号なし整数を、最小のサイズで出力する
+func msgpackUint(buf *bytes.Buffer, n uint64) {
+	switch {
+	case n <= 0x7f:
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+// 文字列を出力する
+func msgpackString(buf *bytes.Buffer, s string) {
+	msgpackHeader(buf, len(s), 0xa0, 0xd9, 0xda, 0xdb)
+	buf.WriteString(s)
+}
+
+// 要素数に応じたヘッダを出力する。fixed は要素数を含めた1バイトの形式、size8/16/32 は要素数のサイズ毎の形式(0 の場合は使用しない)
+func msgpackHeader(buf *bytes.Buffer, n int, fixed, size8, size16, size32 byte) {
+	switch {
+	case fixed != 0 && (fixed == 0xa0 && n < 32 || fixed != 0xa0 && n < 16):
+		buf.WriteByte(fixed | byte(n))
+	case size8 != 0 && n <= math.MaxUint8:
+		buf.WriteByte(size8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(size16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(size32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
@@ -0,0 +1,29 @@
+package encoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// NDJSON : スライスの各要素を、1行毎のJSON(JSON Lines)へ変換する。スライス以外の場合は1行のみ出力する
+func NDJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	rv := indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array || rv.Type().Elem().Kind() == reflect.Uint8 {
+		if err := enc.Encode(v); err != nil {
+			return nil, fmt.Errorf("ndjson: %s", err)
+		}
+		return buf.Bytes(), nil
+	}
+	// json.Encoder は、1要素毎に改行を付与する
+	for i := 0; i < rv.Len(); i++ {
+		if err := enc.Encode(rv.Index(i).Interface()); err != nil {
+			return nil, fmt.Errorf("ndjson: [%d]: %s", i, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,130 @@
+package encoder
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// YAML として解釈が変わるため、引用符で囲む文字列
+var yamlReserved = regexp.MustCompile(`^(?i:~|null|true|false|yes|no|on|off|y|n|[-+]?(\.inf|\.nan|[0-9][0-9_]*(\.[0-9_]*)?([eE][-+]?[0-9]+)?|\.[0-9_]+([eE][-+]?[0-9]+)?|0x[0-9a-f_]+|0o?[0-7_]+))$`)
+
+// YAML : データをYAML形式へ変換する。構造体のキー名は yaml タグ、json タグ、フィールド名の順に決定する
+func YAML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := yamlNode(&buf, reflect.ValueOf(v), 0, true, ""); err != nil {
+		return nil, fmt.Errorf("yaml: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// 値を出力する。top が false の場合は、"key:" または "-" の直後に続けて出力する
+func yamlNode(buf *bytes.Buffer, v reflect.Value, indent int, top bool, path string) error {
+	v = indirect(v)
+	// スカラ値の場合は、同じ行へ出力する
+	if s, ok, err := marshalText(v); ok {
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		return yamlScalar(buf, yamlString(s), top)
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+		return yamlScalar(buf, "!!binary "+base64.StdEncoding.EncodeToString(v.Bytes()), top)
+	}
+	switch v.Kind() {
+	case reflect.Invalid:
+		return yamlScalar(buf, "null", top)
+	case reflect.String:
+		return yamlScalar(buf, yamlString(v.String()), top)
+	case reflect.Map:
+		keys, names, err := sortedKeys(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		if len(keys) == 0 {
+			return yamlScalar(buf, "{}", top)
+		}
+		if !top {
+			buf.WriteString("\n")
+		}
+		for i, key := range keys {
+			yamlKey(buf, names[i], indent)
+			if err := yamlNode(buf, v.MapIndex(key), indent+2, false, join(path, names[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		var list []field
+		for _, f := range fields(v.Type(), "yaml") {
+			if f.omitempty && isEmpty(fieldValue(v, f.index)) {
+				continue
+			}
+			list = append(list, f)
+		}
+		if len(list) == 0 {
+			return yamlScalar(buf, "{}", top)
+		}
+		if !top {
+			buf.WriteString("\n")
+		}
+		for _, f := range list {
+			yamlKey(buf, f.name, indent)
+			if err := yamlNode(buf, fieldValue(v, f.index), indent+2, false, join(path, f.name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		if v.Len() == 0 {
+			return yamlScalar(buf, "[]", top)
+		}
+		if !top {
+			buf.WriteString("\n")
+		}
+		for i := 0; i < v.Len(); i++ {
+			buf.WriteString(strings.Repeat(" ", indent) + "-")
+			if err := yamlNode(buf, v.Index(i), indent+2, false, join(path, fmt.Sprintf("[%d]", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s, ok, _ := scalar(v)
+	if !ok {
+		return &UnsupportedTypeError{Path: path, Type: v.Type()}
+	}
+	return yamlScalar(buf, s, top)
+}
+
+// スカラ値を出力する
+func yamlScalar(buf *bytes.Buffer, s string, top bool) error {
+	if !top {
+		buf.WriteString(" ")
+	}
+	buf.WriteString(s + "\n")
+	return nil
+}
+
+// マップのキーを出力する
+func yamlKey(buf *bytes.Buffer, key string, indent int) {
+	buf.WriteString(strings.Repeat(" ", indent) + yamlString(key) + ":")
+}
+
+// 必要に応じて、文字列を引用符で囲む
+func yamlString(s string) string {
+	if s == "" || yamlReserved.MatchString(s) || strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@` \t") ||
+		strings.HasSuffix(s, " ") || strings.HasSuffix(s, ":") || strings.Contains(s, ": ") || strings.Contains(s, " #") ||
+		strings.IndexFunc(s, func(r rune) bool { return r < 0x20 || r == 0x7f }) != -1 {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.Encode(s)
+		return strings.TrimSuffix(buf.String(), "\n")
+	}
+	return s
+}
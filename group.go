@@ -0,0 +1,221 @@
+package mux
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Routes : ルーティングテーブルへルートを登録するインタフェース(ex: *router.Routes)
+type Routes interface {
+	Register(method, path, name string)
+}
+
+// Group : 共通のプレフィックス、ミドルウェア、IP制限を持つルートグループ
+// New 実行前に定義すること
+type Group struct {
+	mux    *Mux
+	routes Routes
+	prefix string
+}
+
+// Group : BaseURL からの相対パスをプレフィックスとするルートグループを生成する
+// ex) mux.Group("/admin", routes).Register("GET", "/users/{id}", "Admin.User") => /baseurl/admin/users/{id}
+func (mux *Mux) Group(prefix string, routes Routes) *Group {
+	return &Group{
+		mux:    mux,
+		routes: routes,
+		prefix: strings.TrimRight("/"+strings.Trim(prefix, "/"), "/"),
+	}
+}
+
+// Group : プレフィックス、ミドルウェア、IP制限を引き継いだ、入れ子のルートグループを生成する
+func (g *Group) Group(prefix string) *Group {
+	return g.mux.Group(g.Path(prefix), g.routes)
+}
+
+// Prefix : ルートグループのプレフィックスを返却する
+func (g *Group) Prefix() string {
+	if g.prefix == "" {
+		return "/"
+	}
+	return g.prefix
+}
+
+// Path : ルートグループのプレフィックスを付与したパスを返却する
+// ex) /admin + /users => /admin/users
+func (g *Group) Path(path string) string {
+	path = strings.TrimLeft(path, "/")
+	if path == "" {
+		return g.Prefix()
+	}
+	return g.prefix + "/" + path
+}
+
+// Register : プレフィックスを付与したパスで、ルートを登録する
+func (g *Group) Register(method, path, name string) {
+	g.routes.Register(method, g.Path(path), name)
+}
+
+// Use : ルートグループ配下へのリクエストに適用するミドルウェアを登録する
+func (g *Group) Use(middlewares ...Middleware) {
+	g.mux.UsePath(g.Prefix(), middlewares...)
+}
+
+// Allow : ルートグループ配下へのアクセスを許可するIPアドレスを登録する
+// ルートグループのIP制限は、Mux.RestrictIP とは別に、ルートグループ毎に登録した順に判定する
+// 許可しないIPアドレスは、続けて Deny("all") で拒否すること
+func (g *Group) Allow(addr ...string) {
+	g.mux.restrict(g.Prefix(), &IP{IsAllow: true, Path: g.Prefix(), Addr: addr})
+}
+
+// Deny : ルートグループ配下へのアクセスを拒否するIPアドレスを登録する
+func (g *Group) Deny(addr ...string) {
+	g.mux.restrict(g.Prefix(), &IP{IsAllow: false, Path: g.Prefix(), Addr: addr})
+}
+
+// ルートグループのIP制限を登録する
+func (mux *Mux) restrict(prefix string, ip *IP) {
+	if mux.groupIP == nil {
+		mux.groupIP = make(map[string]RestrictIP)
+	}
+	mux.groupIP[prefix] = append(mux.groupIP[prefix], ip)
+}
+
+// クエリパスへのアクセスを、IPアドレスに許可するか否かを判定する
+// Mux.RestrictIP と、クエリパスに該当するすべてのルートグループのIP制限で許可されている場合のみ許可する
+func (mux *Mux) allowIP(path, addr string) bool {
+	if mux.RestrictIP.Contains(path, addr) == false {
+		return false
+	}
+	for _, iplist := range mux.groupIP {
+		if iplist.Contains(path, addr) == false {
+			return false
+		}
+	}
+	return true
+}
+
+// クエリパスに適用されるIP制限を返却する
+func (mux *Mux) restrictIP(path string) RestrictIP {
+	var result RestrictIP
+	path = strings.TrimRight(path, "/") + "/"
+	for _, iplist := range append([]RestrictIP{mux.RestrictIP}, mux.groupRestrictIP()...) {
+		for _, ip := range iplist {
+			if strings.Index(path, strings.TrimRight(ip.Path, "/")+"/") == 0 {
+				result = append(result, ip)
+			}
+		}
+	}
+	return result
+}
+
+// ルートグループのIP制限を、プレフィックス順に返却する
+func (mux *Mux) groupRestrictIP() []RestrictIP {
+	var prefixes []string
+	for prefix := range mux.groupIP {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	var result []RestrictIP
+	for _, prefix := range prefixes {
+		result = append(result, mux.groupIP[prefix])
+	}
+	return result
+}
+
+// Mount : プレフィックス毎に、独立した Mux をマウントする
+// 各 Mux の BaseURL はプレフィックスに置き換えられる。クッキーセッションを使用する場合は、Mux 毎に異なるクッキー名を設定すること
+// ex) handler, err := mux.Mount{"/": public, "/admin": admin}.New()
+type Mount map[string]*Mux
+
+// New : マウントした Mux を初期化し、プレフィックスに応じて Mux を振り分ける MountHandler を生成する
+func (m Mount) New() (*MountHandler, error) {
+	var result = &MountHandler{}
+	for prefix, mux := range m {
+		mux.BaseURL = prefix
+		handler, err := mux.New()
+		if err != nil {
+			// 初期化済みの Mux を停止する
+			result.Close()
+			return nil, err
+		}
+		result.entries = append(result.entries, &mountEntry{prefix: mux.BaseURL, handler: handler, mux: mux})
+	}
+	result.sort()
+	return result, nil
+}
+
+// マウントした Mux
+type mountEntry struct {
+	prefix  string
+	handler http.Handler
+	mux     *Mux
+}
+
+// MountHandler : プレフィックスに応じて、マウントした Mux へ振り分ける http.Handler
+type MountHandler struct {
+	entries []*mountEntry
+}
+
+// プレフィックスが長い順に並べる
+func (m *MountHandler) sort() {
+	for _, entry := range m.entries {
+		entry.prefix = strings.TrimRight(entry.prefix, "/")
+	}
+	sort.Slice(m.entries, func(i, j int) bool {
+		return len(m.entries[i].prefix) > len(m.entries[j].prefix)
+	})
+}
+
+// ServeHTTP : クエリパスに該当するプレフィックスの Mux へ振り分ける。該当しない場合は 404 とする
+func (m *MountHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, entry := range m.entries {
+		if entry.prefix == "" || r.URL.Path == entry.prefix || strings.HasPrefix(r.URL.Path, entry.prefix+"/") {
+			entry.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// Shutdown : マウントしたすべての Mux の新規リクエストの受付を停止し、処理中のリクエストが完了するか、ctx が終了するまで待つ
+// 最初に発生したエラーを返却する
+func (m *MountHandler) Shutdown(ctx context.Context) error {
+	var wg sync.WaitGroup
+	var errs = make([]error, len(m.entries))
+	for i, entry := range m.entries {
+		if entry.mux == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, mux *Mux) {
+			defer wg.Done()
+			errs[i] = mux.Shutdown(ctx)
+		}(i, entry.mux)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close : マウントしたすべての Mux の新規リクエストの受付を停止する。処理中のリクエストの完了は待たない
+// 最初に発生したエラーを返却する
+func (m *MountHandler) Close() error {
+	var result error
+	for _, entry := range m.entries {
+		if entry.mux == nil {
+			continue
+		}
+		if err := entry.mux.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
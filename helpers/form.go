@@ -17,10 +17,12 @@ type Multipart string
 
 // Form : <form>タグを生成する構造体
 type Form struct {
-	data    map[string]interface{}
-	method  string
-	keyname string
-	end     bool
+	data      map[string]interface{}
+	method    string
+	keyname   string
+	end       bool
+	tokenname string
+	token     func() string
 }
 
 func (form *Form) String() string {
@@ -45,6 +47,10 @@ func (form *Form) String() string {
 		// 閉じタグ生成
 		if strings.ToUpper(form.method) != "GET" {
 			result += fmt.Sprintf("<input type='hidden' name='%s' value='%s' />", form.keyname, form.method)
+			// CSRF対策が有効な場合、CSRFトークンを付与する
+			if form.token != nil {
+				result += fmt.Sprintf("<input type='hidden' name='%s' value='%s' />", form.tokenname, form.token())
+			}
 		}
 		result += "</form"
 	}
@@ -3,6 +3,7 @@ package helpers
 import (
 	"fmt"
 	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -227,6 +228,28 @@ func Test_Href(t *testing.T) {
 	}
 }
 
+// {{urlfor}} テスト
+func Test_URLFor(t *testing.T) {
+	helper := CreateHelper()
+	// URL を生成する関数が未設定の場合はエラーとなる
+	if _, err := helper.URLFor("Example.World", 1); err == nil {
+		t.Fatal("ERROR")
+	}
+	helper.Reverse = func(name string, args ...interface{}) (string, error) {
+		if name != "Example.World" || len(args) != 1 {
+			return "", fmt.Errorf("'%s' route not found", name)
+		}
+		return fmt.Sprintf("/world/%v", args[0]), nil
+	}
+	// BaseURL の文字列が付与されたクエリパスが返却される
+	if url, err := helper.URLFor("Example.World", 1); err != nil || url != "/baseurl/world/1" {
+		t.Fatal(url, err)
+	}
+	if _, err := helper.URLFor("Example.Hello"); err == nil {
+		t.Fatal("ERROR")
+	}
+}
+
 // {{url}} テスト
 func Test_URL(t *testing.T) {
 	helper := CreateHelper()
@@ -258,3 +281,80 @@ func Test_URL(t *testing.T) {
 		t.Fatal("ERROR")
 	}
 }
+
+// {{flash}} テスト
+func Test_Flash(t *testing.T) {
+	helper := CreateHelper()
+	// 一度きりのデータが未設定の場合は、空文字列を返却する
+	if helper.Flash("notice") != StringType("") || helper.HasFlash("notice") {
+		t.Fatal("ERROR")
+	}
+	helper.Flashes = Parameters{
+		"notice": "saved",
+		"errors": []interface{}{"error1", "error2"},
+	}
+	if helper.Flash("notice") != "saved" || helper.HasFlash("notice") == false {
+		t.Fatal("ERROR")
+	}
+	if fmt.Sprint(helper.Flash("errors")) != "[error1 error2]" {
+		t.Fatal("ERROR")
+	}
+}
+
+// {{csrftoken}}, {{csrfmeta}} テスト
+func Test_CSRF(t *testing.T) {
+	helper := CreateHelper()
+	// CSRF対策が無効の場合は、トークンを付与しない
+	if helper.CSRFToken() != "" || helper.CSRFMeta() != "" {
+		t.Fatal("ERROR")
+	}
+	helper.Form()
+	form, _ := helper.Form()
+	if strings.Index(form.String(), "_csrf") != -1 {
+		t.Fatal(form.String())
+	}
+
+	helper.CSRFName = "_csrf"
+	helper.CSRFHeader = "X-CSRF-Token"
+	helper.CSRFValue = func() string { return "token" }
+	if helper.CSRFToken() != "token" {
+		t.Fatal("ERROR")
+	}
+	if helper.CSRFMeta() != "<meta name='csrf-header' content='X-CSRF-Token' />\n<meta name='csrf-token' content='token' />" {
+		t.Fatal(helper.CSRFMeta())
+	}
+	// POST フォームの閉じタグに、トークンが付与される
+	helper.Form()
+	form, _ = helper.Form()
+	if form.String() != "<input type='hidden' name='_method' value='POST' /><input type='hidden' name='_csrf' value='token' /></form>" {
+		t.Fatal(form.String())
+	}
+	// GET フォームには付与されない
+	helper.Form("$GET")
+	form, _ = helper.Form()
+	if form.String() != "</form>" {
+		t.Fatal(form.String())
+	}
+}
+
+// {{stylesheet}}, {{script}} テスト
+func Test_Assets(t *testing.T) {
+	helper := CreateHelper()
+	// プリコンパイルされていない場合は、リンクIDを付与する
+	if helper.Stylesheet("assets/application.css") != "<link rel='stylesheet' type='text/css' href='baseurl/assets/application.css?id=0123456789' />" {
+		t.Fatal(helper.Stylesheet("assets/application.css"))
+	}
+	// プリコンパイル済みの場合は、フィンガープリント付きのパスを返却する
+	helper.Assets = func(path string) string {
+		if path == "assets/application.js" {
+			return "assets/application-0123456789abcdef.js"
+		}
+		return ""
+	}
+	if helper.Script("assets/application.js") != "<script src='baseurl/assets/application-0123456789abcdef.js'></script>" {
+		t.Fatal(helper.Script("assets/application.js"))
+	}
+	if helper.Script("assets/other.js") != "<script src='baseurl/assets/other.js?id=0123456789'></script>" {
+		t.Fatal(helper.Script("assets/other.js"))
+	}
+}
@@ -13,16 +13,28 @@ import (
 
 // Helpers : ビュー内で使用する関数群を管理する構造体
 type Helpers struct {
-	MethodName   string       // <form>タグ生成時に付与されるメソッド名を取り出すキー名
-	FormData     *Form        // <form>タグを生成するマップ
-	Locale       locale.Parse // 言語パース
-	LangData     locale.Data  // 言語設定情報
-	Params       Parameters   // controller, action, language, charset,
-	LinkID       string       // <link rel=... 時に同時に付与されるリンクID
-	BaseURL      string       // ベースURL
-	RemoteURI    *URL         // URL情報
-	SubmitMethod string       // リクエスト情報に付与されるメソッド名
-}
+	MethodName   string        // <form>タグ生成時に付与されるメソッド名を取り出すキー名
+	FormData     *Form         // <form>タグを生成するマップ
+	Locale       locale.Parse  // 言語パース
+	LangData     locale.Data   // 言語設定情報
+	Params       Parameters    // controller, action, language, charset,
+	LinkID       string        // <link rel=... 時に同時に付与されるリンクID
+	BaseURL      string        // ベースURL
+	RemoteURI    *URL          // URL情報
+	SubmitMethod string        // リクエスト情報に付与されるメソッド名
+	Flashes      Parameters    // 前回のリクエストでセットされた一度きりのデータ
+	CSRFName     string        // CSRFトークンを送信するパラメータ名
+	CSRFHeader   string        // CSRFトークンを送信するヘッダ名
+	CSRFValue    func() string // CSRFトークンを返却する関数
+	Assets       AssetPath     // 静的ファイルのパスを、プリコンパイル済みのパスへ変換する関数
+	Reverse      URLBuilder    // コントローラ名、アクション名からクエリパスを生成する関数
+}
+
+// AssetPath : 静的ファイルのパスを、プリコンパイル済みのパスへ変換する関数。該当しない場合は空文字列を返却する
+type AssetPath func(path string) string
+
+// URLBuilder : コントローラ名、アクション名("コントローラ名.アクション名")とパラメータから、BaseURL を含まないクエリパスを生成する関数
+type URLBuilder func(name string, args ...interface{}) (string, error)
 
 // Add : 足し算コマンド
 func (cmd *Helpers) Add(a, b int) int {
@@ -167,9 +179,11 @@ func (cmd *Helpers) Form(params ...interface{}) (*Form, error) {
 		data: map[string]interface{}{
 			"action": cmd.RemoteURI.URL.Path,
 		},
-		keyname: cmd.MethodName,
-		end:     false,
-		method:  "POST",
+		keyname:   cmd.MethodName,
+		end:       false,
+		method:    "POST",
+		tokenname: cmd.CSRFName,
+		token:     cmd.CSRFValue,
 	}
 	cmd.FormData = form
 
@@ -227,6 +241,23 @@ func (cmd *Helpers) Form(params ...interface{}) (*Form, error) {
 	return form, nil
 }
 
+// CSRFToken : CSRFトークンを返却する。CSRF対策が無効の場合は空文字列を返却する
+func (cmd *Helpers) CSRFToken() StringType {
+	if cmd.CSRFValue == nil {
+		return ""
+	}
+	return StringType(cmd.CSRFValue())
+}
+
+// CSRFMeta : JavaScript から CSRF トークンを送信するための <meta> タグを埋め込む
+func (cmd *Helpers) CSRFMeta() string {
+	if cmd.CSRFValue == nil {
+		return ""
+	}
+	return fmt.Sprintf("<meta name='csrf-header' content='%s' />\n<meta name='csrf-token' content='%s' />",
+		cmd.CSRFHeader, cmd.CSRFValue())
+}
+
 // Controller : コントローラ名を返却する
 func (cmd *Helpers) Controller() StringType {
 	return cmd.Params.T("controller")
@@ -289,6 +320,19 @@ func (cmd *Helpers) Parameter(name string) interface{} {
 	return cmd.Params.T(name)
 }
 
+// Flash : 前回のリクエストでセットされた一度きりのデータを取り出す。存在しない場合は空文字列を返却する
+func (cmd *Helpers) Flash(name string) interface{} {
+	if v, ok := cmd.Flashes[name]; ok {
+		return v
+	}
+	return StringType("")
+}
+
+// HasFlash : 前回のリクエストで、指定した名前の一度きりのデータがセットされたか否かを検出する
+func (cmd *Helpers) HasFlash(name string) bool {
+	return cmd.Flashes.HasItem(name)
+}
+
 // T : 言語情報を取得する
 func (cmd *Helpers) T(name string) interface{} {
 	if cmd.LangData == nil {
@@ -346,6 +390,10 @@ func (cmd *Helpers) Env(name string) StringType {
 
 // Stylesheet : <link rel='stylesheet' ... タグを埋め込む
 func (cmd *Helpers) Stylesheet(path string) string {
+	// プリコンパイル済みの場合、ファイル名で内容を識別できるため、idクエリパラメータなしの <link>を生成する
+	if compiled := cmd.assetPath(path); compiled != "" {
+		return fmt.Sprintf("<link rel='stylesheet' type='text/css' href='%s' />", compiled)
+	}
 	path = filepath.Join(cmd.BaseURL, path)
 	// リンクIDがない場合、idクエリパラメータなしの <link>を生成する
 	if cmd.LinkID == "" {
@@ -357,6 +405,10 @@ func (cmd *Helpers) Stylesheet(path string) string {
 
 // Script : <script src='...' タグを埋め込む
 func (cmd *Helpers) Script(path string) string {
+	// プリコンパイル済みの場合、idクエリパラメータなしの <script>を生成する
+	if compiled := cmd.assetPath(path); compiled != "" {
+		return fmt.Sprintf("<script src='%s'></script>", compiled)
+	}
 	path = filepath.Join(cmd.BaseURL, path)
 	// リンクIDがない場合、idクエリパラメータなしの <link>を生成する
 	if cmd.LinkID == "" {
@@ -366,6 +418,17 @@ func (cmd *Helpers) Script(path string) string {
 	return fmt.Sprintf("<script src='%s?id=%s'></script>", path, cmd.LinkID)
 }
 
+// プリコンパイル済みの静的ファイルのパスを、BaseURL を考慮したパスで返却する。プリコンパイルされていない場合は空文字列を返却する
+func (cmd *Helpers) assetPath(path string) string {
+	if cmd.Assets == nil {
+		return ""
+	}
+	if compiled := cmd.Assets(path); compiled != "" {
+		return filepath.Join(cmd.BaseURL, compiled)
+	}
+	return ""
+}
+
 // URL : アクセス先URLを返却する
 func (cmd *Helpers) URL() *URL {
 	return cmd.RemoteURI
@@ -424,6 +487,18 @@ func (cmd *Helpers) Href(path StringType) string {
 	return "/" + strings.Join(paths, "/")
 }
 
+// URLFor : コントローラ名、アクション名("コントローラ名.アクション名")とパラメータから、リンク先を生成する
+func (cmd *Helpers) URLFor(name string, args ...interface{}) (string, error) {
+	if cmd.Reverse == nil {
+		return "", fmt.Errorf("'%s': URL generation is not available", name)
+	}
+	path, err := cmd.Reverse(name, args...)
+	if err != nil {
+		return "", err
+	}
+	return cmd.Href(StringType(path)), nil
+}
+
 // Method : メソッド名を取得する
 func (cmd *Helpers) Method() string {
 	return cmd.SubmitMethod
@@ -3,6 +3,7 @@ package mux
 import (
 	"fmt"
 	"net"
+	"net/http"
 	"strings"
 )
 
@@ -106,3 +107,12 @@ func (iplist RestrictIP) Contains(path, addr string) bool {
 	// 該当パスが存在しない場合は true を返却する
 	return true
 }
+
+// アクセス元のIPアドレスを返却する。IPv6 のアドレス([::1]:80)にも対応する
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,212 @@
+package mux
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// JSONP のコールバック関数名として使用できる文字列
+var callbackName = regexp.MustCompile(`^[a-zA-Z_$][0-9a-zA-Z_$]*(\.[a-zA-Z_$][0-9a-zA-Z_$]*)*$`)
+
+// Pretty : JSON/XML をインデントして出力する
+func (r *RenderTemplate) Pretty() *RenderTemplate {
+	r.indent = true
+	return r
+}
+
+// EscapeHTML : JSON 内の '<', '>', '&' をエスケープするか否かを指定する(デフォルトはエスケープする)
+func (r *RenderTemplate) EscapeHTML(escape bool) *RenderTemplate {
+	r.noescape = !escape
+	return r
+}
+
+// Callback : JSON を、指定したコールバック関数の呼び出し(JSONP)として出力する。空文字列の場合は JSON のまま出力する
+// ex) c.Render().Data(data).JSON().Callback(c.Query().Get("callback"))
+func (r *RenderTemplate) Callback(name string) *RenderTemplate {
+	r.callback = name
+	return r
+}
+
+// Root : スライスを XML で出力する際の、ルート要素名を指定する(デフォルトは items)
+func (r *RenderTemplate) Root(name string) *RenderTemplate {
+	r.root = name
+	return r
+}
+
+// JSON へ変換する。変換に失敗した場合は、失敗したデータの位置を含めた MarshalError を返却する
+func marshalJSON(v interface{}, indent, escape bool) ([]byte, error) {
+	marshal := func(v interface{}) ([]byte, error) {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(escape)
+		if indent {
+			enc.SetIndent("", "  ")
+		}
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+		// json.Encoder が付与する改行を除去する
+		return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+	}
+	buf, err := marshal(v)
+	if err != nil {
+		return nil, marshalError("json", v, err, marshal)
+	}
+	return buf, nil
+}
+
+// XML へ変換する。スライスの場合は、root で指定したルート要素で囲む
+func marshalXML(v interface{}, indent bool, root string) ([]byte, error) {
+	var prefix string
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	// スライスの場合は要素毎に出力されるため、ルート要素の内側へ出力する
+	slice := (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8
+	if slice && indent {
+		prefix = "  "
+	}
+	marshal := func(v interface{}) ([]byte, error) {
+		if indent {
+			return xml.MarshalIndent(v, prefix, "  ")
+		}
+		return xml.Marshal(v)
+	}
+
+	buf, err := marshal(v)
+	if err != nil {
+		return nil, marshalError("xml", v, err, marshal)
+	}
+	if slice {
+		if root == "" {
+			root = "items"
+		}
+		if indent && len(buf) != 0 {
+			buf = append(append([]byte("<"+root+">\n"), buf...), []byte("\n</"+root+">")...)
+		} else {
+			buf = append(append([]byte("<"+root+">"), buf...), []byte("</"+root+">")...)
+		}
+	}
+	return buf, nil
+}
+
+// JSONP として出力する
+func jsonp(name string, buf []byte) ([]byte, error) {
+	if callbackName.MatchString(name) == false {
+		return nil, &MarshalError{Message: fmt.Sprintf("'%s' invalid callback name", name)}
+	}
+	// Content-Type を誤認させる攻撃を防ぐため、先頭にコメントを付与する
+	return []byte("/**/" + name + "(" + string(buf) + ");"), nil
+}
+
+// 変換に失敗したデータの位置を特定し、MarshalError を生成する
+func marshalError(format string, v interface{}, err error, marshal func(interface{}) ([]byte, error)) error {
+	path := failedPath(reflect.ValueOf(v), format, marshal, make(map[uintptr]bool))
+	message := err.Error()
+	if path != "" {
+		message = fmt.Sprintf("%s: %s", path, message)
+	}
+	return &MarshalError{Message: format + ": " + message, Path: path}
+}
+
+// 変換に失敗する子要素を探索し、その位置を返却する。子要素がすべて変換できる場合は、自身の位置(空文字列)を返却する
+// 循環参照しているデータの探索を終了するため、探索済みのポインタ、マップ、スライスを visited に記録する
+func failedPath(v reflect.Value, tag string, marshal func(interface{}) ([]byte, error), visited map[uintptr]bool) string {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return ""
+		}
+		// Marshaler を実装している場合は、子要素を探索しない
+		if v.Kind() == reflect.Ptr && implementsMarshaler(v.Type()) {
+			return ""
+		}
+		if v.Kind() == reflect.Ptr && visit(v, visited) == false {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || implementsMarshaler(v.Type()) {
+		return ""
+	}
+	if (v.Kind() == reflect.Map || v.Kind() == reflect.Slice) && visit(v, visited) == false {
+		return ""
+	}
+
+	// 子要素の変換を試みる
+	failed := func(child reflect.Value) bool {
+		if !child.IsValid() || !child.CanInterface() {
+			return false
+		}
+		_, err := marshal(child.Interface())
+		return err != nil
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(f.Tag.Get(tag), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if child := v.Field(i); failed(child) {
+				return joinPath(name, failedPath(child, tag, marshal, visited))
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			if child := v.MapIndex(key); failed(child) {
+				return joinPath(fmt.Sprint(key.Interface()), failedPath(child, tag, marshal, visited))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if child := v.Index(i); failed(child) {
+				return joinPath(fmt.Sprintf("[%d]", i), failedPath(child, tag, marshal, visited))
+			}
+		}
+	}
+	return ""
+}
+
+// ポインタ、マップ、スライスを探索済みとして記録する。探索済みの場合は false を返却する
+func visit(v reflect.Value, visited map[uintptr]bool) bool {
+	if v.IsNil() {
+		return true
+	}
+	if visited[v.Pointer()] {
+		return false
+	}
+	visited[v.Pointer()] = true
+	return true
+}
+
+// json/xml の Marshaler を実装しているか否かを判定する
+func implementsMarshaler(t reflect.Type) bool {
+	return t.Implements(reflect.TypeOf((*json.Marshaler)(nil)).Elem()) ||
+		t.Implements(reflect.TypeOf((*xml.Marshaler)(nil)).Elem())
+}
+
+// データの位置を連結する (ex: users + [0] => users[0], users + name => users.name)
+func joinPath(parent, child string) string {
+	if child == "" {
+		return parent
+	}
+	if strings.HasPrefix(child, "[") {
+		return parent + child
+	}
+	return parent + "." + child
+}
@@ -0,0 +1,191 @@
+package mux
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ochipin/mux/basemux"
+	"github.com/ochipin/mux/helpers"
+)
+
+// Handler : ミドルウェアから呼び出す後続の処理。復帰値はアクションの復帰値(Result)、または error となる
+// HTTPMiddleware で包んだ後続の処理は、実行結果を出力済みのため、書き込み済みを示す値を返却する
+type Handler func(w http.ResponseWriter, r *http.Request, v *Values) interface{}
+
+// Middleware : 後続の処理を包むミドルウェア
+// next を呼び出さずに Result、または error を返却した場合は、以降の処理を実行せずにその復帰値を出力する
+type Middleware func(next Handler) Handler
+
+// ミドルウェアの登録情報
+type middlewares struct {
+	global  []Middleware            // 全リクエストに適用するミドルウェア
+	paths   []*pathMiddleware       // クエリパス毎に適用するミドルウェア
+	actions map[string][]Middleware // アクション毎に適用するミドルウェア
+	routes  bool                    // ルート一覧を出力するミドルウェアの登録有無
+}
+
+// クエリパス毎に適用するミドルウェア
+type pathMiddleware struct {
+	path        string       // BaseURL からの相対パス(ex: /api)
+	middlewares []Middleware // 適用するミドルウェア
+}
+
+// Use : 全リクエストに適用するミドルウェアを登録する。ミドルウェアは登録した順に実行される
+// サーバ起動前にコールすること
+func (mux *Mux) Use(middlewares ...Middleware) {
+	mux.use().global = append(mux.use().global, middlewares...)
+}
+
+// UsePath : BaseURL 配下の、指定したクエリパス以下へのリクエストに適用するミドルウェアを登録する
+// ex) mux.UsePath("/api", auth) => /baseurl/api, /baseurl/api/users 等に適用する
+// Use で登録したミドルウェアの後に、登録した順に実行される。サーバ起動前にコールすること
+func (mux *Mux) UsePath(path string, middlewares ...Middleware) {
+	path = "/" + strings.Trim(path, "/")
+	mux.use().paths = append(mux.use().paths, &pathMiddleware{path: path, middlewares: middlewares})
+}
+
+// UseAction : 指定したアクション("コントローラ名.アクション名")に適用するミドルウェアを登録する
+// ルーティング、CSRFトークンの検証後、事前関数、アクション、事後関数の実行を包む。サーバ起動前にコールすること
+func (mux *Mux) UseAction(execname string, middlewares ...Middleware) {
+	m := mux.use()
+	if m.actions == nil {
+		m.actions = make(map[string][]Middleware)
+	}
+	m.actions[execname] = append(m.actions[execname], middlewares...)
+}
+
+// ミドルウェアの登録情報を返却する。未登録の場合は生成する
+func (mux *Mux) use() *middlewares {
+	if mux.middlewares == nil {
+		mux.middlewares = &middlewares{}
+	}
+	return mux.middlewares
+}
+
+// クエリパスに適用するミドルウェアを、実行する順に返却する
+func (m *middlewares) lookup(path string) []Middleware {
+	if m == nil {
+		return nil
+	}
+	var result = append([]Middleware{}, m.global...)
+	for _, p := range m.paths {
+		if p.path == "/" || path == p.path || strings.HasPrefix(path, p.path+"/") {
+			result = append(result, p.middlewares...)
+		}
+	}
+	return result
+}
+
+// アクションに適用するミドルウェアを返却する
+func (m *middlewares) action(execname string) []Middleware {
+	if m == nil {
+		return nil
+	}
+	return m.actions[execname]
+}
+
+// ミドルウェアで handler を包む。先頭のミドルウェアが最初に実行される
+func chain(middlewares []Middleware, handler Handler) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// HTTPMiddleware : net/http 形式のミドルウェアを Middleware へ変換する
+// リクエスト、ヘッダの変更は後続の処理へ引き継がれる。アクションの復帰値、エラー画面は、ミドルウェアが包んだ http.ResponseWriter へ出力する
+// next を呼び出さない場合は、ミドルウェアがレスポンスを書き込むこと。何も書き込まなかった場合は InvalidReturn エラーとなる
+func (mux *Mux) HTTPMiddleware(fn func(http.Handler) http.Handler) Middleware {
+	return func(next Handler) Handler {
+		return func(w http.ResponseWriter, r *http.Request, v *Values) interface{} {
+			var called bool
+			tracker := &writeTracker{ResponseWriter: w}
+			fn(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				mux.writeResult(w, r, v, next(w, r, v))
+			})).ServeHTTP(tracker, r)
+			if called == false && tracker.wrote == false {
+				return &InvalidReturn{
+					Message: "middleware neither called next handler nor wrote a response",
+				}
+			}
+			return &written{}
+		}
+	}
+}
+
+// アクションの復帰値を変換し、w へ出力する。エラーの場合は、エラー画面を出力する
+func (mux *Mux) writeResult(w http.ResponseWriter, r *http.Request, v *Values, object interface{}) {
+	helper, _ := v.Val("helpers").(*helpers.Helpers)
+	result := mux.result(w, r, v, helper, object)
+	// 出力前に、変更されたセッションをクッキーへ保存する。ミドルウェアを重ねた場合も、保存は一度のみ行う
+	mux.saveSession(w, v)
+	switch types := result.(type) {
+	case error:
+		mux.Error(types, &basemux.ResponseWriter{ResponseWriter: w, Values: v}, r)
+	case basemux.Render:
+		types.Render(w, r)
+	}
+}
+
+// レスポンスを書き込んだか否かを検知する http.ResponseWriter
+type writeTracker struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+// WriteHeader : ステータスコードを書き込む
+func (t *writeTracker) WriteHeader(code int) {
+	t.wrote = true
+	t.ResponseWriter.WriteHeader(code)
+}
+
+// Write : レスポンスを書き込む
+func (t *writeTracker) Write(b []byte) (int, error) {
+	t.wrote = true
+	return t.ResponseWriter.Write(b)
+}
+
+// Flush : 書き込んだ内容をクライアントへフラッシュする
+func (t *writeTracker) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		t.wrote = true
+		f.Flush()
+	}
+}
+
+// Hijack : 接続を乗っ取る
+func (t *writeTracker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := t.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not implement http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		t.wrote = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap : 元の http.ResponseWriter を返却する
+func (t *writeTracker) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// レスポンスを書き込み済みであることを示す復帰値
+type written struct{}
+
+func (*written) pointer() {}
+
+// Render : 書き込み済みのため、何もしない
+func (*written) Render(w http.ResponseWriter, r *http.Request) {}
+
+// Controller : ミドルウェアから Render, Redirect 等の復帰値を生成するためのコントローラを返却する
+// 該当するアクションがない場合、コントローラ名、アクション名は空文字列となる
+func (mux *Mux) Controller(w http.ResponseWriter, r *http.Request, v *Values) *Controller {
+	ctlname, actname := v.Get("ctlname"), v.Get("actname")
+	return mux.controller(w, r, v, ctlname, actname, mux.I18n(r, ctlname, actname))
+}
@@ -1,11 +1,13 @@
 package mux
 
 import (
-	"encoding/json"
-	"encoding/xml"
+	"crypto/sha256"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
@@ -13,7 +15,10 @@ import (
 	"github.com/ochipin/locale"
 	"github.com/ochipin/logger/errorlog"
 	"github.com/ochipin/mux/basemux"
+	"github.com/ochipin/mux/encoder"
 	"github.com/ochipin/mux/helpers"
+	"github.com/ochipin/mux/session"
+	"github.com/ochipin/mux/websocket"
 	"github.com/ochipin/render"
 	"github.com/ochipin/render/core"
 	"github.com/ochipin/report"
@@ -41,10 +46,35 @@ type Mux struct {
 	Helpers     interface{}             // ヘルパ
 	RestrictIP  RestrictIP              // IP制限
 	Trigger     Trigger                 // トリガ
+	Session     *session.Config         // クッキーセッションの設定
+	CSRF        *CSRF                   // CSRF対策の設定
+	WebSocket   *websocket.Upgrader     // WebSocket の設定
+	AssetsDir   string                  // 静的ファイルのディレクトリ。Last-Modified の判定、ファイルの直接送信に使用する
+	AssetsCache map[string]string       // 拡張子毎の静的ファイルの Cache-Control
+	Precompiled string                  // プリコンパイル済み静的ファイルの出力先ディレクトリ
+	Encoders    map[string]*Encoder     // Format 関数で指定する形式毎の変換処理
+	RouteTable  *RouteTable             // ルーティングテーブルへの登録内容。Router、Tables が未設定の場合は RouteTable から生成する
+	RoutesPath  string                  // 開発用。ルート一覧を出力するクエリパス(BaseURL からの相対パス)。未設定の場合は出力しない
+	manifest    *manifest               // プリコンパイル済み静的ファイルの管理情報
+	middlewares *middlewares            // 登録されたミドルウェア
+	groupIP     map[string]RestrictIP   // ルートグループ毎のIP制限
 }
 
 // New : Mux を初期化し、http.Handler を生成する関数
 func (mux *Mux) New() (http.Handler, error) {
+	// ルーティングテーブルへの登録内容から、ルーティングテーブルを生成する
+	if t := mux.RouteTable; t != nil {
+		if mux.Router == nil {
+			routes, err := t.Create()
+			if err != nil {
+				return nil, err
+			}
+			mux.Router = routes
+		}
+		if mux.Tables == nil {
+			mux.Tables = t.TableList()
+		}
+	}
 	// ルーティングテーブル未設定の場合はエラーとする
 	if mux.Router == nil {
 		return nil, fmt.Errorf("no route table")
@@ -87,7 +117,7 @@ func (mux *Mux) New() (http.Handler, error) {
 			Exclude:   regexp.MustCompile(`(^|[|\n])//=\s*(.+?)\s*$|(^|[|\n])/\*=\s*([\s\S]+?)\s*\*/`),
 			Cache:     true,
 			Binary:    true,
-			MaxSize:   5 << 20,
+			MaxSize:   maxAssetSize,
 		}
 		v, err := c.New()
 		if err != nil {
@@ -96,6 +126,21 @@ func (mux *Mux) New() (http.Handler, error) {
 		mux.StaticFiles = v
 	}
 
+	// 静的ファイルのディレクトリが未設定の場合、デフォルト値を適用する
+	if mux.AssetsDir == "" {
+		mux.AssetsDir = "app/assets"
+	}
+	// 静的ファイルの Cache-Control が未設定の場合、毎回 ETag で更新の有無を確認する
+	if mux.AssetsCache == nil {
+		mux.AssetsCache = map[string]string{
+			"*": "no-cache",
+		}
+	}
+	// プリコンパイル済み静的ファイルのマニフェストが存在する場合は読み込む
+	if err := mux.LoadManifest(); err != nil {
+		return nil, err
+	}
+
 	// ロギングインタフェースが未設定の場合、デフォルト値を設定する
 	if mux.Log == nil {
 		logger := &errorlog.Log{
@@ -139,6 +184,21 @@ func (mux *Mux) New() (http.Handler, error) {
 		mux.ContentList = basemux.ContentList()
 	}
 
+	// 標準で提供する形式のうち、登録されていない形式を登録する
+	if mux.Encoders == nil {
+		mux.Encoders = make(map[string]*Encoder)
+	}
+	for name, enc := range encoder.Defaults() {
+		if _, ok := mux.Encoders[name]; !ok {
+			mux.Encoders[name] = enc
+		}
+	}
+
+	// レスポンス圧縮の圧縮対象が未設定の場合、Content-Type リストのテキスト形式を圧縮する
+	if mux.Compress != nil && mux.Compress.Types == nil {
+		mux.Compress.Types = basemux.TextTypes(mux.ContentList)
+	}
+
 	// BaseURL が未登録の場合は、'/' が BaseURL とする
 	mux.BaseURL = strings.Trim(mux.BaseURL, " ")
 	if mux.BaseURL == "" {
@@ -163,6 +223,29 @@ func (mux *Mux) New() (http.Handler, error) {
 		return nil, fmt.Errorf("'Helpers' parameter type not struct")
 	}
 
+	// 同じクエリパスに該当する可能性のあるルートを出力する
+	for _, problem := range mux.overlaps() {
+		mux.Log.Warning(problem)
+	}
+	if mux.Router == nil {
+		return nil, fmt.Errorf("routing table is not created")
+	}
+	// 検証用のクエリパスでアクションを取得できなかったルートは、警告のみ出力する
+	// アクションの定義に誤りがあるルートが存在する場合は、エラーとする
+	errs, notfound := mux.checkActions()
+	for _, e := range notfound {
+		mux.Log.Warning(e.Error())
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	// ルート一覧を出力するクエリパスが設定されている場合は、ルート一覧を出力するミドルウェアを登録する
+	// New を複数回呼び出した場合でも、登録は 1 度のみとする
+	if mux.RoutesPath != "" && mux.use().routes == false {
+		mux.Use(mux.routesEndpoint())
+		mux.use().routes = true
+	}
+
 	// IP制限設定をされている場合、IPNetを初期化する
 	if mux.RestrictIP == nil {
 		mux.RestrictIP = RestrictIP{}
@@ -172,11 +255,50 @@ func (mux *Mux) New() (http.Handler, error) {
 			return nil, err
 		}
 	}
+	for _, iplist := range mux.groupIP {
+		if err := iplist.MakeIPNet(); err != nil {
+			return nil, err
+		}
+	}
 
 	// トリガ未設定の場合は、空トリガを記憶させる
 	if mux.Trigger == nil {
 		mux.Trigger = &BaseTrigger{}
 	}
+
+	// クッキーセッションが未設定の場合、デフォルト値を適用する
+	if mux.Session == nil {
+		mux.Session = &session.Config{}
+	}
+	// CSRF対策は、CSRF を設定した場合のみ有効とする
+	if mux.CSRF == nil {
+		mux.CSRF = &CSRF{Disable: true}
+	}
+	// 起動毎に生成する署名鍵では、再起動後や複数インスタンス間でトークンを検証できないため、署名鍵の設定を必須とする
+	if mux.CSRF.Disable == false && len(mux.Session.Keys) == 0 {
+		return nil, fmt.Errorf("'CSRF' requires 'Session.Keys'")
+	}
+	mux.CSRF.init()
+	// クッキーのパスが未設定の場合は、BaseURL をパスとする
+	if mux.Session.Path == "" {
+		mux.Session.Path = mux.BaseURL
+	}
+	if err := mux.Session.Init(); err != nil {
+		return nil, err
+	}
+
+	// リンクIDの署名鍵が設定されている場合、リンクIDをクッキーセッションに紐付ける
+	if mux.LinkKey != nil && mux.LinkOwner == nil {
+		mux.LinkOwner = func(r *http.Request, v *Values) string {
+			return sessionToken(mux.LoadSession(r, v), linkKey)
+		}
+	}
+
+	// WebSocket の設定が未設定の場合、デフォルト値を適用する
+	if mux.WebSocket == nil {
+		mux.WebSocket = &websocket.Upgrader{}
+	}
+
 	mux.Handler = mux
 	return mux.GenerateHandler()
 }
@@ -209,6 +331,12 @@ func (mux *Mux) Main(w http.ResponseWriter, r *http.Request, refer basemux.Refer
 	// アクション実行結果を受け取る
 	object := mux.CallAction(w, r, v)
 
+	// セッションが変更されている場合は、出力前にクッキーへ保存する
+	// ミドルウェアで出力済みの場合は、出力前に保存済みのため保存しない
+	if _, ok := object.(*written); !ok {
+		mux.saveSession(w, v)
+	}
+
 	switch result := object.(type) {
 	// エラー
 	case error:
@@ -223,6 +351,19 @@ func (mux *Mux) Main(w http.ResponseWriter, r *http.Request, refer basemux.Refer
 	return nil, err
 }
 
+// セッションが変更されている場合は、クッキーへ保存する。保存はリクエスト毎に一度のみ行う
+func (mux *Mux) saveSession(w http.ResponseWriter, v *Values) {
+	if v.Val("sessionSaved") != nil {
+		return
+	}
+	v.SetLocal("sessionSaved", true)
+	if sess, ok := v.Val("session").(*session.Session); ok {
+		if err := mux.Session.Save(w, sess); err != nil {
+			mux.Log.Error(err)
+		}
+	}
+}
+
 // CallAction : アクション実行結果を判定し、返却する
 func (mux *Mux) CallAction(w http.ResponseWriter, r *http.Request, v *Values) interface{} {
 	mux.Log.Debug("BEGIN")
@@ -233,19 +374,40 @@ func (mux *Mux) CallAction(w http.ResponseWriter, r *http.Request, v *Values) in
 			"lang":    mux.Locale.Lookup(r.Header.Get("Accept-Language")),
 		},
 		MethodName:   mux.MethodName,
-		LinkID:       v.ID(),
+		LinkID:       v.LinkID(),
 		RemoteURI:    &helpers.URL{URL: r.URL},
 		Locale:       mux.Locale,
 		BaseURL:      mux.BaseURL,
 		LangData:     mux.I18n(r, "", ""),
 		SubmitMethod: r.Method,
+		CSRFName:     mux.CSRF.FieldName,
+		CSRFHeader:   mux.CSRF.Header,
+	}
+	// CSRF対策が有効な場合のみ、フォームへトークンを付与する
+	if mux.CSRF.Disable == false {
+		helper.CSRFValue = func() string {
+			return csrfToken(mux.LoadSession(r, v))
+		}
 	}
-	v.Set("defaultHelper", mux.Trigger.SetHelper(helper))
+	helper.Assets = mux.assetPath(helper)
+	helper.Reverse = mux.reversePath
+	v.SetLocal("defaultHelper", mux.Trigger.SetHelper(helper))
 	mux.Log.Debug("default helper created.")
 
+	// HTTPMiddleware で包んだハンドラ内で実行結果を出力するため、ヘルパを記憶させる
+	v.SetLocal("helpers", helper)
+
 	// アクションを実行し、実行結果を判定する
+	result := mux.result(w, r, v, helper, mux.ExecAction(w, r, v, helper))
+
+	mux.Log.Debug("END")
+	return result
+}
+
+// アクションの実行結果を判定し、basemux.Render、または error へ変換する
+func (mux *Mux) result(w http.ResponseWriter, r *http.Request, v *Values, helper *helpers.Helpers, object interface{}) interface{} {
 	var result interface{}
-	switch object := mux.ExecAction(w, r, v, helper).(type) {
+	switch object := object.(type) {
 	// エラーが発生している場合は、どのタイプのエラーか判定する
 	case error:
 		result = object
@@ -254,17 +416,61 @@ func (mux *Mux) CallAction(w http.ResponseWriter, r *http.Request, v *Values) in
 		switch types := object.(type) {
 		// HTML/TEXT/JSON/XML のいずれかの表示
 		case *RenderTemplate:
+			// 前回のリクエストでセットされた一度きりのデータをヘルパへ渡す。データは表示後に破棄される
+			helper.Flashes = helpers.Parameters(mux.LoadSession(r, v).Flashes())
+			// Negotiate で出力しない場合は、クエリパスの拡張子で指定された形式で出力できないため、406 とする
+			if types.formats == nil && v.Get("format") != "" {
+				return &NotAcceptable{
+					Message:    fmt.Sprintf("'.%s' is not acceptable. action does not negotiate the format", v.Get("format")),
+					StatusCode: 406,
+					Accept:     v.Get("format"),
+				}
+			}
+			// Negotiate で出力する場合は、Accept ヘッダ、またはクエリパスの拡張子から形式を決定する
+			if types.formats != nil {
+				if err := mux.negotiate(w, r, types, v.Get("format")); err != nil {
+					mux.Log.Error(err)
+					return err
+				}
+			}
 			object, err := mux.Render(types, v)
 			if err != nil {
 				mux.Log.Error(err)
+				// JSON/XML の出力に失敗した場合は、エラーも同じ形式で出力する
+				if (types.ext == "json" || types.ext == "xml") && v.Get("errorformat") == "" {
+					v.SetLocal("errorformat", types.ext)
+				}
 				return err
 			}
+			// 静的ファイルのリクエストから参照できるよう、呼び出し元の情報を保存する
+			for _, key := range []string{"ctlname", "actname", "execname", "linkid"} {
+				v.Set(key, v.Val(key))
+			}
 			// RenderTemplate で登録されたオリジナルヘルパ、データを記憶させる
 			v.Set("helper", types.helper)
 			v.Set("data", types.data)
 			result = object
 		// 静的ファイルを処理の表示
 		case *AssetsTemplate:
+			// プリコンパイル済みの静的ファイルの場合、出力済みのファイルをそのまま表示する
+			if mux.manifest.compiled(types.path) {
+				object, err := mux.Compiled(types)
+				if err != nil {
+					return err
+				}
+				result = object
+				break
+			}
+			// gzip で圧縮済みのファイルが存在し、クライアントが gzip に対応している場合は、圧縮済みのファイルを表示する
+			if object := mux.Precompressed(r, types); object != nil {
+				result = object
+				break
+			}
+			// バイナリファイル、またはテンプレートとして処理できない大きなファイルは、ディスクから逐次送信する
+			if object := mux.AssetFile(types); object != nil {
+				result = object
+				break
+			}
 			var id string
 			// クエリパラメータからリンクIDを取得
 			if query := r.URL.Query(); query != nil {
@@ -274,12 +480,12 @@ func (mux *Mux) CallAction(w http.ResponseWriter, r *http.Request, v *Values) in
 			source := v.Old(id)
 			if source != nil {
 				// バッファ内容を呼び出し元へ書き換える
-				v.Set("ctlname", source.Get("ctlname"))
-				v.Set("actname", source.Get("actname"))
-				v.Set("execname", source.Get("ctlname")+"."+source.Get("actname"))
-				v.Set("linkid", source.Get("linkid"))
-				v.Set("helper", source.Val("helper"))
-				v.Set("data", v.Val("data"))
+				v.SetLocal("ctlname", source.Get("ctlname"))
+				v.SetLocal("actname", source.Get("actname"))
+				v.SetLocal("execname", source.Get("ctlname")+"."+source.Get("actname"))
+				v.SetLocal("linkid", source.Get("linkid"))
+				v.SetLocal("helper", source.Val("helper"))
+				v.SetLocal("data", v.Val("data"))
 				// 多言語情報を取得する
 				langdata := mux.I18n(r, v.Get("ctlname"), v.Get("actname"))
 				// ヘルパのデータを書き換える
@@ -296,20 +502,45 @@ func (mux *Mux) CallAction(w http.ResponseWriter, r *http.Request, v *Values) in
 			result = object
 		// リダイレクトの場合
 		case *Redirect:
+			// リダイレクト先の URL を生成できなかった場合は、エラーとする
+			if types.err != nil {
+				mux.Log.Error(types.err)
+				return types.err
+			}
 			// https, http から始まる文字列ではない場合、BaseURLを考慮したパスに変換する
 			if !regexp.MustCompile(`^http[s]*://`).MatchString(types.path) {
-				// /baseurl/path/to/url/ => baseurl/path/to/url
-				trimpath := strings.Trim(mux.BaseURL+"/"+types.path, "/")
-				// baseurl/path/to/url => [baseurl path to url]
-				paths := strings.Fields(strings.Replace(trimpath, "/", " ", -1))
-				// [baseurl path to url] => /baseurl/path/to/url
-				types.path = "/" + strings.Join(paths, "/")
+				types.path = joinBaseURL(mux.BaseURL, types.path)
+			}
+			// リダイレクト先へ渡す一度きりのデータを、セッションへ保存する
+			if len(types.flashes) != 0 {
+				sess := mux.LoadSession(r, v)
+				for key, val := range types.flashes {
+					sess.Flash(key, val)
+				}
 			}
 			// リダイレクト用オブジェクトを作成
 			result = &Render{
 				Path:       types.path,
 				StatusCode: types.statuscode,
 			}
+		// レスポンスボディを逐次書き込む場合
+		case *Stream:
+			result = types.view()
+		// Server-Sent Events を送信する場合
+		case *EventStream:
+			result = types.view()
+		// WebSocket で通信する場合
+		case *WebSocket:
+			result = types
+		// io.Reader から読み込んだ内容をファイルとして送信する場合
+		case *SendFile:
+			result = types.view()
+		// ミドルウェアがレスポンスを書き込み済みの場合
+		case *written:
+			result = types
+		// ミドルウェアが返却した basemux.Render は、そのまま出力する
+		case basemux.Render:
+			result = types
 		// 上記以外の場合、復帰値エラーとして扱う
 		default:
 			result = &InvalidReturn{
@@ -318,7 +549,6 @@ func (mux *Mux) CallAction(w http.ResponseWriter, r *http.Request, v *Values) in
 		}
 	}
 
-	mux.Log.Debug("END")
 	return result
 }
 
@@ -331,27 +561,67 @@ func (mux *Mux) ExecAction(w http.ResponseWriter, r *http.Request, v *Values, he
 		return err
 	}
 
+	// ルーティングテーブルから該当するアクションを取得し、PrePostRegister をコールする
+	// 該当するアクションがない場合のエラーは、ミドルウェアを経由した後に返却する
+	act, err := mux.prepareAction(w, r, v, helper)
+
+	// 安全ではないリクエストメソッドの場合は、ミドルウェアの実行前に CSRF トークンを検証する
+	if mux.csrfExempt(r, act) == false {
+		if err := mux.VerifyCSRF(r, v); err != nil {
+			mux.Log.Error(err)
+			return err
+		}
+	}
+
+	// 全リクエスト、クエリパス毎に登録されたミドルウェアを経由して、アクションを実行する
+	path, _ := mux.trimBaseURL(r.URL.Path)
+	handler := chain(mux.middlewares.lookup(path), func(w http.ResponseWriter, r *http.Request, v *Values) interface{} {
+		if err != nil {
+			mux.Log.Error(err)
+			return err
+		}
+		return mux.execAction(w, r, v, act)
+	})
+	result := handler(w, r, v)
+
+	mux.Log.Debug("END")
+	return result
+}
+
+// 実行の準備が完了したアクション
+type preparedAction struct {
+	fn         reflect.Value   // 実行するアクション
+	args       []reflect.Value // アクションの引数
+	controller *Controller     // アクションにセットした基本コントローラ
+	prepost    *PrePost        // PrePostRegister で設定した内容
+	ctlname    string          // コントローラ名
+	actname    string          // アクション名
+}
+
+// ルーティングテーブルから該当するアクションを取得し、コントローラのセットと PrePostRegister のコールを行う
+func (mux *Mux) prepareAction(w http.ResponseWriter, r *http.Request, v *Values, helper *helpers.Helpers) (*preparedAction, error) {
 	// ルーティングテーブルから、アクセスされたクエリパスに該当するアクション情報を取得する
-	res, args, err := mux.RoutePath(r)
+	res, args, format, err := mux.routePath(r)
 	if err != nil {
-		mux.Log.Error(err)
-		return err
+		return nil, err
 	}
+	// クエリパスの拡張子で指定された形式を記憶させる
+	v.SetLocal("format", format)
 
 	// アクション情報を取得
 	action, _ := res.Get()
 	// アクション情報が所持するコントローラ名とアクション名を取得
 	ctlname, actname := res.Name()
 	// リンクIDを記憶させる
-	linkid := v.ID()
+	linkid := v.LinkID()
 	// 多言語情報を取得する
 	langdata := mux.I18n(r, ctlname, actname)
 
 	// 一時バッファに格納する
-	v.Set("ctlname", ctlname)
-	v.Set("actname", actname)
-	v.Set("execname", ctlname+"."+actname)
-	v.Set("linkid", linkid)
+	v.SetLocal("ctlname", ctlname)
+	v.SetLocal("actname", actname)
+	v.SetLocal("execname", ctlname+"."+actname)
+	v.SetLocal("linkid", linkid)
 	mux.Log.Debug("buffer setting complete")
 
 	// ヘルパのデータを完成させる
@@ -364,30 +634,17 @@ func (mux *Mux) ExecAction(w http.ResponseWriter, r *http.Request, v *Values, he
 	mux.Log.Debug("helpers.Helper parameters set complete")
 
 	// 基本コントローラを生成
-	controller := &Controller{
-		w:           w,                                  // http.ResponseWriter
-		r:           r,                                  // *http.Request
-		controller:  ctlname,                            // コントローラ名
-		action:      actname,                            // アクション名
-		locale:      langdata,                           // 多言語設定
-		files:       uploadfile.New(r, mux.UploadFiles), // アップロードファイルの成約
-		path:        r.URL.Path,                         // クエリパス
-		Log:         mux.Log,                            // ロギング
-		contentlist: mux.ContentList,                    // Content-Type 一覧
-	}
+	controller := mux.controller(w, r, v, ctlname, actname, langdata)
 
 	// アクション情報に、コントローラをセット
 	mux.Trigger.SetController(action, v)
 	if err := router.SetStruct(action, controller); err != nil {
-		mux.Log.Error(err)
-		return err
+		return nil, err
 	}
-
 	// 実行するアクションが正しい情報で構築されているか確認
 	fn, err := res.Valid(action, args, "mux.Result")
 	if err != nil {
-		mux.Log.Error(err)
-		return err
+		return nil, err
 	}
 
 	// 型情報に問題がなければコントローラが所持するPrePostRegisterをコールする
@@ -396,66 +653,130 @@ func (mux *Mux) ExecAction(w http.ResponseWriter, r *http.Request, v *Values, he
 		reflect.ValueOf(prepost),
 	})
 	if err != nil {
-		mux.Log.Error(err)
-		return err
+		return nil, err
 	}
 
-	// アクション実行前に、事前関数を実行する
-	for _, v := range prepost.begins {
-		// 事前関数の復帰値が、nil以外の場合は、処理を中断し関数を復帰する
-		if result := v(); result != nil {
-			return result
+	// 拡張子を除いたクエリパスに該当した場合、拡張子で形式を指定できないアクションは 404 とする
+	if format != "" && prepost.pathFormat(actname) == false {
+		return nil, &router.NotRoutes{
+			Message: fmt.Sprintf("'[%s]: %s' - '%s' does not accept '.%s' extension", r.Method, r.URL.Path, ctlname+"."+actname, format),
+			Path:    r.URL.Path,
+			Method:  r.Method,
 		}
 	}
-	// アクションを実行し、実行結果を返却する
-	out := fn.Call(args)
-	// アクション実行後、事後関数を実行する
-	for _, v := range prepost.commits {
-		// 事後関数の復帰値が、nil以外の場合は処理を中断し関数を復帰する
-		if result := v(); result != nil {
-			return result
-		}
+
+	// エラー発生時の形式が設定されている場合は、形式を記憶させる
+	if format := prepost.errorFormat(actname); format != "" {
+		v.SetLocal("errorformat", format)
 	}
 
-	// 事前、事後関数共に復帰値がnilの場合、アクション実行結果を検証する
-	if out[0].Interface() == nil {
-		return nil
+	return &preparedAction{
+		fn:         fn,
+		args:       args,
+		controller: controller,
+		prepost:    prepost,
+		ctlname:    ctlname,
+		actname:    actname,
+	}, nil
+}
+
+// 実行の準備が完了したアクションを実行する
+func (mux *Mux) execAction(w http.ResponseWriter, r *http.Request, v *Values, act *preparedAction) interface{} {
+	prepost, controller := act.prepost, act.controller
+	ctlname, actname := act.ctlname, act.actname
+
+	// アクションのタイムアウト時間が設定されている場合は、タイムアウト時間を変更する
+	if timeout := prepost.timeout(actname); timeout > 0 {
+		basemux.SetTimeout(r, timeout)
+		mux.Log.Debugf("'%s.%s' timeout is %d seconds", ctlname, actname, timeout)
 	}
 
-	mux.Log.Debug("END")
-	return out[0].Interface()
+	// アクションに登録されたミドルウェアを経由して、事前関数、アクション、事後関数を実行する
+	handler := chain(mux.middlewares.action(ctlname+"."+actname), func(w http.ResponseWriter, r *http.Request, v *Values) interface{} {
+		// ミドルウェアで変更されたリクエストを、コントローラへ反映する
+		controller.w, controller.r = w, r
+		// アクション実行前に、事前関数を実行する
+		for _, v := range prepost.begins {
+			// 事前関数の復帰値が、nil以外の場合は、処理を中断し関数を復帰する
+			if result := v(); result != nil {
+				return result
+			}
+		}
+		// アクションを実行し、実行結果を返却する
+		out := act.fn.Call(act.args)
+		// アクション実行後、事後関数を実行する
+		for _, v := range prepost.commits {
+			// 事後関数の復帰値が、nil以外の場合は処理を中断し関数を復帰する
+			if result := v(); result != nil {
+				return result
+			}
+		}
+
+		// 事前、事後関数共に復帰値がnilの場合、アクション実行結果を検証する
+		if out[0].Interface() == nil {
+			return nil
+		}
+		return out[0].Interface()
+	})
+	return handler(w, r, v)
+}
+
+// コントローラを生成する
+func (mux *Mux) controller(w http.ResponseWriter, r *http.Request, v *Values, ctlname, actname string, langdata locale.Data) *Controller {
+	// クッキーセッションは、アクションが使用した時点で復元する
+	loadSession := func() *session.Session {
+		return mux.LoadSession(r, v)
+	}
+	return &Controller{
+		w:           w,                                  // http.ResponseWriter
+		r:           r,                                  // *http.Request
+		controller:  ctlname,                            // コントローラ名
+		action:      actname,                            // アクション名
+		locale:      langdata,                           // 多言語設定
+		files:       uploadfile.New(r, mux.UploadFiles), // アップロードファイルの成約
+		path:        r.URL.Path,                         // クエリパス
+		Log:         mux.Log,                            // ロギング
+		contentlist: mux.ContentList,                    // Content-Type 一覧
+		session:     loadSession,                        // クッキーセッション
+		upgrader:    mux.WebSocket,                      // WebSocket の設定
+		reverse:     mux.reversePath,                    // URL の生成
+	}
+}
+
+// LoadSession : リクエストのクッキーからセッションを復元する。同一リクエスト内では、復元済みのセッションを返却する
+func (mux *Mux) LoadSession(r *http.Request, v *Values) *session.Session {
+	if sess, ok := v.Val("session").(*session.Session); ok {
+		return sess
+	}
+	sess := mux.Session.Load(r)
+	v.SetLocal("session", sess)
+	return sess
 }
 
 // RoutePath : アクセスされたクエリパスから該当するアクションを取得する
 func (mux *Mux) RoutePath(r *http.Request) (router.Result, []reflect.Value, error) {
-	var path = r.URL.Path
+	res, args, _, err := mux.routePath(r)
+	return res, args, err
+}
+
+// クエリパスに該当するアクション情報と、クエリパスの拡張子(.json/.xml)で指定された形式を取得する
+// 拡張子付きのクエリパスに該当するアクションがない場合は、拡張子を除いたクエリパスで検索する
+// 拡張子を除いたクエリパスに該当したアクションが、拡張子で形式を指定できるか否か(PrePost.PathFormat)は、呼び出し元で判定する
+func (mux *Mux) routePath(r *http.Request) (router.Result, []reflect.Value, string, error) {
 	// 先頭のクエリパスがBaseURLで設定したパスではない場合、エラーを返却する
-	if strings.Index(path, mux.BaseURL) != 0 {
-		return nil, nil, &router.NotRoutes{
+	path, ok := mux.trimBaseURL(r.URL.Path)
+	if !ok {
+		return nil, nil, "", &router.NotRoutes{
 			Message: fmt.Sprintf("'[%s]: %s' - not found", r.Method, r.URL.Path),
 			Path:    r.URL.Path,
 			Method:  r.Method,
 		}
 	}
-	// /baseurl/path/to/url => /path/to/url へ変換する
-	if mux.BaseURL != "/" {
-		path = path[len(mux.BaseURL):]
-		if path == "" {
-			path = "/"
-		}
-		if path[0] != '/' {
-			path = r.URL.Path
-		}
-	}
 
 	// IP 制限がかかっていないか確認する
-	var addr string
-	idx := strings.Index(r.RemoteAddr, ":")
-	if idx != -1 {
-		addr = r.RemoteAddr[:idx]
-	}
-	if mux.RestrictIP.Contains(path, addr) == false {
-		return nil, nil, &AccessDenied{
+	addr := remoteAddr(r)
+	if mux.allowIP(path, addr) == false {
+		return nil, nil, "", &AccessDenied{
 			Message:    "access forbidden by rule, client: " + addr,
 			IP:         addr,
 			StatusCode: 403,
@@ -463,18 +784,51 @@ func (mux *Mux) RoutePath(r *http.Request) (router.Result, []reflect.Value, erro
 	}
 
 	// アクセスされたクエリパスに該当するアクションを取得する
-	res, args, err := mux.Router.Caller(r.Method, path)
+	res, args, err := mux.caller(r.Method, path)
 	// アクション取得成功の場合、アクションの情報を返却する
 	if err == nil {
-		return res, args, nil
+		return res, args, "", nil
+	}
+
+	// 拡張子で形式が指定されている場合は、拡張子を除いたクエリパスに該当するアクションを取得する
+	if trimpath, format := mux.pathFormat(path); format != "" {
+		if res, args, e := mux.caller(r.Method, trimpath); e == nil {
+			return res, args, format, nil
+		}
+	}
+
+	// ルーティングテーブルから該当するアクションが見つからない場合は、エラーを返却する
+	return nil, nil, "", err
+}
+
+// クエリパスから BaseURL を除いたパスを返却する。BaseURL 配下のパスではない場合は false を返却する
+// ex) /baseurl/path/to/url => /path/to/url
+func (mux *Mux) trimBaseURL(path string) (string, bool) {
+	if strings.Index(path, mux.BaseURL) != 0 {
+		return path, false
+	}
+	if mux.BaseURL != "/" {
+		trimpath := path[len(mux.BaseURL):]
+		if trimpath == "" {
+			trimpath = "/"
+		}
+		if trimpath[0] == '/' {
+			return trimpath, true
+		}
 	}
+	return path, true
+}
 
+// メソッドとクエリパスに該当するアクションを取得する
+func (mux *Mux) caller(method, path string) (router.Result, []reflect.Value, error) {
+	res, args, err := mux.Router.Caller(method, path)
+	if err == nil {
+		return res, args, nil
+	}
 	// 該当するアクションが見つからない場合、"*" で登録されたクエリパスがないか確認する
 	if res, args, err := mux.Router.Caller("*", path); err == nil {
 		return res, args, nil
 	}
-
-	// ルーティングテーブルから該当するアクションが見つからない場合は、エラーを返却する
 	return nil, nil, err
 }
 
@@ -535,20 +889,26 @@ func (mux *Mux) Render(r *RenderTemplate, v *Values) (basemux.Render, error) {
 	switch r.ext {
 	// JSON関数がコールされている場合、Data関数で登録されたデータをJSONとして処理する
 	case "json":
-		var data map[string]interface{}
-		buf, _ := json.Marshal(r.data)
-		if err := json.Unmarshal(buf, &data); err != nil {
+		buf, err := marshalJSON(r.data, r.indent, !r.noescape)
+		if err != nil {
 			mux.Log.Error(err)
-			return nil, &MarshalError{err.Error()}
+			return nil, err
+		}
+		// コールバック関数名が指定されている場合は、JSONP として出力する
+		if r.callback != "" {
+			if buf, err = jsonp(r.callback, buf); err != nil {
+				mux.Log.Error(err)
+				return nil, err
+			}
+			result.ContentType = fmt.Sprintf("application/javascript; %s", mux.Charset)
 		}
 		result.Buffer = buf
 	// XML関数がコールされている場合、Data関数で登録されたデータをXMLとして処理する
 	case "xml":
-		var data map[string]interface{}
-		buf, _ := xml.Marshal(r.data)
-		if err := xml.Unmarshal(buf, &data); err != nil {
+		buf, err := marshalXML(r.data, r.indent, r.root)
+		if err != nil {
 			mux.Log.Error(err)
-			return nil, &MarshalError{err.Error()}
+			return nil, err
 		}
 		result.Buffer = buf
 	// HTML/TEXT関数がコールされている場合、HTML、またはTEXTとして処理する
@@ -560,6 +920,21 @@ func (mux *Mux) Render(r *RenderTemplate, v *Values) (basemux.Render, error) {
 			return nil, err
 		}
 		result.Buffer = buf
+	// Format関数で指定された形式の場合、登録されている変換処理でデータを変換する
+	default:
+		enc, ok := mux.Encoders[r.ext]
+		if !ok || enc == nil {
+			err := &MarshalError{Message: fmt.Sprintf("'%s' unknown format", r.ext)}
+			mux.Log.Error(err)
+			return nil, err
+		}
+		buf, err := enc.Encode(r.data)
+		if err != nil {
+			mux.Log.Error(err)
+			return nil, &MarshalError{Message: err.Error()}
+		}
+		result.Buffer = buf
+		result.ContentType = enc.ContentType
 	}
 
 	mux.Log.Debug("END")
@@ -585,8 +960,9 @@ func (mux *Mux) Static(r *AssetsTemplate, v *Values) (basemux.Render, error) {
 
 	// 返却するRender型を生成
 	var result = &Render{
-		StatusCode:  200,
-		ContentType: r.content,
+		StatusCode:   200,
+		ContentType:  r.content,
+		CacheControl: mux.cacheControl(r.path),
 	}
 
 	// 静的ファイルを処理
@@ -595,11 +971,87 @@ func (mux *Mux) Static(r *AssetsTemplate, v *Values) (basemux.Render, error) {
 		return nil, err
 	}
 	result.Buffer = buf
+	// 処理結果のハッシュ値を ETag とし、元ファイルの更新日時を Last-Modified とする
+	sum := sha256.Sum256(buf)
+	result.ETag = fmt.Sprintf(`"%x"`, sum[:16])
+	if info, err := os.Stat(filepath.Join(mux.AssetsDir, r.path)); err == nil {
+		result.LastModified = info.ModTime()
+	}
 
 	mux.Log.Debug("END")
 	return result, nil
 }
 
+// Precompressed : AssetsDir 内に gzip で圧縮済みのファイル(ex: application.css.gz)が存在し、クライアントが gzip に対応している場合、圧縮済みのファイルを返却する
+// 圧縮済みのファイルは、テンプレートとして処理せずにそのまま返却する。該当しない場合は nil を返却する
+func (mux *Mux) Precompressed(req *http.Request, r *AssetsTemplate) basemux.Render {
+	if mux.Compress == nil || mux.Compress.Disable || basemux.AcceptEncoding(req, "gzip") <= 0 {
+		return nil
+	}
+	name := mux.assetName(r.path) + ".gz"
+	info, err := os.Stat(name)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		mux.Log.Error(err)
+		return nil
+	}
+	sum := sha256.Sum256(buf)
+	return &Render{
+		Buffer:       buf,
+		StatusCode:   200,
+		ContentType:  r.content,
+		Encoding:     "gzip",
+		ETag:         fmt.Sprintf(`"%x"`, sum[:16]),
+		LastModified: info.ModTime(),
+		CacheControl: mux.cacheControl(r.path),
+	}
+}
+
+// AssetFile : バイナリファイル、または StaticFiles で処理できるサイズを超えるファイルの場合、Range リクエストに対応してディスクから送信する
+// テキストファイルの場合、サイズが maxAssetSize 以下のファイルは nil を返却する
+func (mux *Mux) AssetFile(r *AssetsTemplate) basemux.Render {
+	name := mux.assetName(r.path)
+	info, err := os.Stat(name)
+	if err != nil || info.Mode().IsRegular() == false {
+		return nil
+	}
+	if mux.textType(r.content) && info.Size() <= maxAssetSize {
+		return nil
+	}
+	return &basemux.FileView{
+		Name:         name,
+		ContentType:  r.content,
+		CacheControl: mux.cacheControl(r.path),
+	}
+}
+
+// 静的ファイルのパスを、AssetsDir 配下のファイル名へ変換する。AssetsDir より上位のディレクトリは参照させない
+func (mux *Mux) assetName(name string) string {
+	return filepath.Join(mux.AssetsDir, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// ContentList のテキスト形式の Content-Type か否かを判定する
+func (mux *Mux) textType(content string) bool {
+	content = strings.TrimSpace(strings.Split(content, ";")[0])
+	for _, t := range basemux.TextTypes(mux.ContentList) {
+		if strings.EqualFold(t, content) {
+			return true
+		}
+	}
+	return false
+}
+
+// 静的ファイルの拡張子に応じた Cache-Control を返却する。拡張子が登録されていない場合は、"*" の設定を返却する
+func (mux *Mux) cacheControl(path string) string {
+	if v, ok := mux.AssetsCache[filepath.Ext(path)]; ok {
+		return v
+	}
+	return mux.AssetsCache["*"]
+}
+
 // Error : エントリポイントからエラーが返却されるとコールされるエラー画面出力関数
 func (mux *Mux) Error(err error, res http.ResponseWriter, req *http.Request) {
 	mux.Log.Debug("BEGIN")
@@ -623,6 +1075,9 @@ func (mux *Mux) Error(err error, res http.ResponseWriter, req *http.Request) {
 		execname = "???.???"
 	}
 
+	// エラー画面の形式を判定する
+	format := mux.errorFormat(res, req, v.Values)
+
 	// エラータイプを判定する
 	var status = &ErrorStatus{
 		Trace:     report.ServeTrace(0, res, req),
@@ -632,6 +1087,24 @@ func (mux *Mux) Error(err error, res http.ResponseWriter, req *http.Request) {
 	}
 
 	switch types := err.(type) {
+	// CSRFトークンの検証に失敗した際のエラー
+	case *CSRFError:
+		status.Title = "403 Forbidden"
+		status.StatusCode = types.StatusCode
+		status.StatusName = "CSRFError"
+		status.ErrorTitle = "Invalid CSRF Token in '" + execname + "'"
+	// Accept ヘッダ、拡張子に対応する形式で出力できない場合のエラー
+	case *NotAcceptable:
+		status.Title = "406 Not Acceptable"
+		status.StatusCode = types.StatusCode
+		status.StatusName = "NotAcceptable"
+		status.ErrorTitle = "Not Acceptable in '" + execname + "'"
+	// WebSocket のハンドシェイクに失敗した際のエラー
+	case *websocket.HandshakeError:
+		status.Title = fmt.Sprintf("%d %s", types.StatusCode, http.StatusText(types.StatusCode))
+		status.StatusCode = types.StatusCode
+		status.StatusName = "WebSocketError"
+		status.ErrorTitle = "WebSocket Handshake Error in '" + execname + "'"
 	// IP制限に引っかかった際のエラー
 	case *AccessDenied:
 		status.Title = "403 Forbidden"
@@ -700,6 +1173,12 @@ func (mux *Mux) Error(err error, res http.ResponseWriter, req *http.Request) {
 		status.StatusCode = 500
 		status.StatusName = "TemplateError"
 		status.ErrorTitle = "Template Error in '" + execname + "'"
+	// コントローラ名、アクション名から URL を生成できない場合のエラー
+	case *ReverseError:
+		status.Title = "500 Internal Server Error"
+		status.StatusCode = 500
+		status.StatusName = "ReverseError"
+		status.ErrorTitle = "URL Generation Error for '" + types.Name + "' in '" + execname + "'"
 	// JSON/XML の解析失敗時のエラー
 	case *MarshalError:
 		status.Title = "500 Internal Server Error"
@@ -741,13 +1220,19 @@ func (mux *Mux) Error(err error, res http.ResponseWriter, req *http.Request) {
 		status.Title = "408 Request Time-out"
 		status.StatusCode = 408
 		status.StatusName = "Timeout"
-		status.ErrorTitle = "Request Time-out in '" + execname + "'"
+		status.ErrorTitle = fmt.Sprintf("Request Time-out (%d seconds) in '%s'", types.Timeout, execname)
 	// 最大同時アクセス数の超過エラー
 	case *basemux.MaxClientsError:
 		status.Title = "503 Service Temporarily Unavailable"
 		status.StatusCode = 503
 		status.StatusName = "MaxClientsOver"
 		status.ErrorTitle = "Max Clients Over in '" + execname + "'"
+	// シャットダウン中のエラー
+	case *basemux.ShutdownError:
+		status.Title = "503 Service Temporarily Unavailable"
+		status.StatusCode = 503
+		status.StatusName = "ShuttingDown"
+		status.ErrorTitle = "Shutting Down in '" + execname + "'"
 	// 401 認証エラー
 	case *Unauthorized:
 		status.Title = "401 Unauthorized"
@@ -806,10 +1291,16 @@ func (mux *Mux) Error(err error, res http.ResponseWriter, req *http.Request) {
 	// 上記以外のエラー
 	default:
 		if mux.Trigger.CustomError(status, err, execname) == false {
-			res.Header().Set("Content-Type", "text/html")
-			res.WriteHeader(500)
-			res.Write([]byte(err.Error()))
-			return
+			if format == "html" {
+				res.Header().Set("Content-Type", "text/html")
+				res.WriteHeader(500)
+				res.Write([]byte(err.Error()))
+				return
+			}
+			status.Title = "500 Internal Server Error"
+			status.StatusCode = 500
+			status.StatusName = "UnknownError"
+			status.ErrorTitle = "Unknown Error in '" + execname + "'"
 		}
 	}
 
@@ -819,6 +1310,18 @@ func (mux *Mux) Error(err error, res http.ResponseWriter, req *http.Request) {
 		mux.Trigger.ErrorReport(status, status.StatusCode, status.StatusName)
 	}()
 
+	// JSON/XML の場合は、RFC 7807 形式で出力する
+	if format != "html" {
+		if err := mux.writeProblem(res, format, mux.Problem(status, req)); err != nil {
+			mux.Log.Error(err)
+			res.Header().Set("Content-Type", "text/plain")
+			res.WriteHeader(status.StatusCode)
+			res.Write([]byte(status.Error()))
+		}
+		mux.Log.Debug("END")
+		return
+	}
+
 	// 静的ファイルを処理
 	buf, err := r.Render("errors.html", status)
 	if err != nil {
@@ -1,11 +1,27 @@
 package mux
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ochipin/mux/basemux"
+	"github.com/ochipin/mux/encoder"
+	"github.com/ochipin/mux/helpers"
+	"github.com/ochipin/mux/session"
 	"github.com/ochipin/router"
 )
 
@@ -133,3 +149,1161 @@ func Test_Mux2(t *testing.T) {
 	res.Body.Read(buf)
 	res.Body.Close()
 }
+
+// CSRFトークンの検証をテストする
+func Test_CSRF(t *testing.T) {
+	mux := &Mux{
+		BaseURL: "/baseurl",
+		Session: &session.Config{},
+		CSRF:    &CSRF{Exempts: []string{"/api"}},
+	}
+	mux.MethodName = "_method"
+	mux.Session.Init()
+	mux.CSRF.init()
+
+	// トークンを発行する
+	v := basemux.NewValues("id", nil, nil)
+	r := httptest.NewRequest("GET", "/baseurl/", nil)
+	token := csrfToken(mux.LoadSession(r, v))
+	if token == "" || token != csrfToken(mux.LoadSession(r, v)) {
+		t.Fatal("ERROR")
+	}
+	w := httptest.NewRecorder()
+	mux.Session.Save(w, mux.LoadSession(r, v))
+	cookie := w.Result().Cookies()[0]
+
+	request := func(method, path string, form url.Values, header string) error {
+		r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			r.Header.Set("X-CSRF-Token", header)
+		}
+		r.AddCookie(cookie)
+		r.ParseForm()
+		if len(r.PostForm["_method"]) != 0 {
+			r.Method = r.PostForm.Get("_method")
+		}
+		return mux.VerifyCSRF(r, basemux.NewValues("id", nil, nil))
+	}
+
+	// 安全なリクエストメソッドは検証しない
+	if err := request("GET", "/baseurl/", url.Values{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	// トークンがない場合はエラー
+	if _, ok := request("POST", "/baseurl/", url.Values{}, "").(*CSRFError); !ok {
+		t.Fatal("ERROR")
+	}
+	// _method で GET に上書きした場合も検証する
+	if _, ok := request("POST", "/baseurl/", url.Values{"_method": {"GET"}}, "").(*CSRFError); !ok {
+		t.Fatal("ERROR")
+	}
+	// トークンが一致しない場合はエラー
+	if _, ok := request("POST", "/baseurl/", url.Values{"_csrf": {"invalid"}}, "").(*CSRFError); !ok {
+		t.Fatal("ERROR")
+	}
+	// パラメータ、またはヘッダのトークンが一致する場合は成功
+	if err := request("POST", "/baseurl/", url.Values{"_csrf": {token}, "_method": {"DELETE"}}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := request("POST", "/baseurl/", url.Values{}, token); err != nil {
+		t.Fatal(err)
+	}
+	// 検証を行わないパスの場合は成功
+	if err := request("POST", "/baseurl/api/users", url.Values{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	// ミドルウェアの実行前の判定。PrePostRegister で SkipCSRF を設定したアクションは検証しない
+	prepost := &PrePost{}
+	prepost.SkipCSRF("Create")
+	index := &preparedAction{prepost: prepost, actname: "Index"}
+	create := &preparedAction{prepost: prepost, actname: "Create"}
+	if mux.csrfExempt(httptest.NewRequest("GET", "/baseurl/", nil), index) == false {
+		t.Fatal("ERROR")
+	}
+	if mux.csrfExempt(httptest.NewRequest("POST", "/baseurl/", nil), index) {
+		t.Fatal("ERROR")
+	}
+	if mux.csrfExempt(httptest.NewRequest("POST", "/baseurl/", nil), create) == false {
+		t.Fatal("ERROR")
+	}
+	// 該当するアクションがない場合は検証する
+	if mux.csrfExempt(httptest.NewRequest("POST", "/undefined", nil), nil) {
+		t.Fatal("ERROR")
+	}
+	// CSRF を設定していない場合は検証しない
+	mux.CSRF.Disable = true
+	if mux.csrfExempt(httptest.NewRequest("POST", "/undefined", nil), nil) == false {
+		t.Fatal("ERROR")
+	}
+}
+
+// ファイル送信のテスト
+func Test_SendFile(t *testing.T) {
+	c := &Controller{contentlist: basemux.ContentList()}
+
+	// ファイル名から Content-Type を、io.Reader からサイズを判定する
+	view := c.SendFile("レポート.csv", strings.NewReader("a,b\n")).view()
+	w := httptest.NewRecorder()
+	if err := view.Stream(w, httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "a,b\n" || w.Header().Get("Content-Length") != "4" ||
+		w.Header().Get("Content-Disposition") != "attachment; filename*=utf-8''%E3%83%AC%E3%83%9D%E3%83%BC%E3%83%88.csv" ||
+		w.Header().Get("Content-Type") != basemux.ContentList()[".csv"] {
+		t.Fatal(w.Header(), w.Body.String())
+	}
+
+	// ブラウザ内で表示する
+	view = c.SendFile("image", strings.NewReader("data")).Type("image/png").Inline().view()
+	w = httptest.NewRecorder()
+	view.Stream(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("Content-Disposition") != "inline; filename=image" || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatal(w.Header())
+	}
+}
+
+// 条件付きリクエストのテスト
+func Test_NotModified(t *testing.T) {
+	modified := time.Date(2018, 1, 1, 0, 0, 0, 500, time.UTC)
+	render := &Render{
+		Buffer:       []byte("body{}"),
+		StatusCode:   200,
+		ContentType:  "text/css",
+		ETag:         `"0123abcd"`,
+		LastModified: modified,
+		CacheControl: "no-cache",
+	}
+	request := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/assets/application.css", nil)
+		r.Header = header
+		w := httptest.NewRecorder()
+		render.Render(w, r)
+		return w
+	}
+
+	// 初回アクセス時は、ETag、Last-Modified、Cache-Control を付与して 200 を返却する
+	w := request(http.Header{})
+	if w.Code != 200 || w.Body.String() != "body{}" || w.Header().Get("ETag") != `"0123abcd"` ||
+		w.Header().Get("Last-Modified") != "Mon, 01 Jan 2018 00:00:00 GMT" || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatal(w.Code, w.Header())
+	}
+	// ETag が一致する場合は 304 を返却する
+	if w := request(http.Header{"If-None-Match": {`"other", W/"0123abcd"`}}); w.Code != 304 || w.Body.Len() != 0 {
+		t.Fatal(w.Code)
+	}
+	// ETag が一致しない場合は、If-Modified-Since を無視して 200 を返却する
+	if w := request(http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {"Mon, 01 Jan 2018 00:00:00 GMT"}}); w.Code != 200 {
+		t.Fatal(w.Code)
+	}
+	// 更新されていない場合は 304、更新されている場合は 200 を返却する
+	if w := request(http.Header{"If-Modified-Since": {"Mon, 01 Jan 2018 00:00:00 GMT"}}); w.Code != 304 {
+		t.Fatal(w.Code)
+	}
+	if w := request(http.Header{"If-Modified-Since": {"Sun, 31 Dec 2017 23:59:59 GMT"}}); w.Code != 200 {
+		t.Fatal(w.Code)
+	}
+
+	// 拡張子毎の Cache-Control
+	mux := &Mux{AssetsCache: map[string]string{"*": "no-cache", ".css": "public, max-age=3600"}}
+	if mux.cacheControl("stylesheets/application.css") != "public, max-age=3600" || mux.cacheControl("javascripts/application.js") != "no-cache" {
+		t.Fatal("ERROR")
+	}
+}
+
+func Test_Precompiled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "precompiled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mux := &Mux{Precompiled: dir}
+	// マニフェストが存在しない場合は、何もしない
+	if err := mux.LoadManifest(); err != nil || mux.manifest != nil {
+		t.Fatal(err)
+	}
+	// 内容のハッシュ値を付与したファイル名で出力する
+	path, err := mux.writeAsset("stylesheets/application.css", []byte("body{}"))
+	if err != nil || !regexp.MustCompile(`^stylesheets/application-[0-9a-f]{16}\.css$`).MatchString(path) {
+		t.Fatal(path, err)
+	}
+	buf, _ := json.Marshal(Manifest{
+		"stylesheets/application.css":               path,
+		"stylesheets/application.css@Example.Hello": "stylesheets/application-0123456789abcdef.css",
+	})
+	ioutil.WriteFile(filepath.Join(dir, manifestName), buf, 0644)
+	if err := mux.LoadManifest(); err != nil {
+		t.Fatal(err)
+	}
+
+	// アクション毎のファイルが存在する場合は、アクション毎のファイルを優先する
+	helper := &helpers.Helpers{Params: helpers.Parameters{"controller": "Example", "action": "World"}}
+	resolve := mux.assetPath(helper)
+	if resolve("assets/stylesheets/application.css") != "assets/"+path || resolve("assets/javascripts/application.js") != "" {
+		t.Fatal(resolve("assets/stylesheets/application.css"))
+	}
+	helper.Params["action"] = "Hello"
+	if resolve("assets/stylesheets/application.css") != "assets/stylesheets/application-0123456789abcdef.css" {
+		t.Fatal(resolve("assets/stylesheets/application.css"))
+	}
+
+	// プリコンパイル済みのファイルは、長期間キャッシュ可能なファイルとして返却する
+	if !mux.manifest.compiled(path) || mux.manifest.compiled("stylesheets/application.css") {
+		t.Fatal("ERROR")
+	}
+	render, err := mux.Compiled(&AssetsTemplate{path: path, content: "text/css"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	render.Render(w, httptest.NewRequest("GET", "/assets/"+path, nil))
+	if w.Code != 200 || w.Body.String() != "body{}" || w.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Fatal(w.Code, w.Header())
+	}
+}
+
+func Test_Precompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "application.css.gz"), []byte("GZIP"), 0644)
+
+	mux := &Mux{
+		Mux:         basemux.Mux{Compress: &basemux.Compress{}},
+		AssetsDir:   dir,
+		AssetsCache: map[string]string{"*": "no-cache"},
+	}
+	request := func(encoding string) *http.Request {
+		r := httptest.NewRequest("GET", "/assets/application.css", nil)
+		r.Header.Set("Accept-Encoding", encoding)
+		return r
+	}
+	css := &AssetsTemplate{path: "application.css", content: "text/css"}
+
+	// gzip に対応していない場合、圧縮済みのファイルが存在しない場合は使用しない
+	if mux.Precompressed(request("gzip;q=0, deflate"), css) != nil {
+		t.Fatal("ERROR")
+	}
+	if mux.Precompressed(request("gzip"), &AssetsTemplate{path: "other.css", content: "text/css"}) != nil {
+		t.Fatal("ERROR")
+	}
+	// 圧縮済みのファイルを、Content-Encoding と Vary を付与して返却する
+	render := mux.Precompressed(request("gzip, deflate"), css)
+	if render == nil {
+		t.Fatal("ERROR")
+	}
+	w := httptest.NewRecorder()
+	render.Render(w, request("gzip"))
+	if w.Body.String() != "GZIP" || w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" ||
+		w.Header().Get("Content-Type") != "text/css" || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatal(w.Header())
+	}
+}
+
+func Test_AssetFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "image.png"), []byte("0123456789"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "style.css"), []byte("body{}"), 0644)
+
+	mux := &Mux{
+		AssetsDir:   dir,
+		AssetsCache: map[string]string{"*": "no-cache"},
+		ContentList: basemux.ContentList(),
+	}
+	// テキストファイル、存在しないファイルは、テンプレートとして処理する
+	if mux.AssetFile(&AssetsTemplate{path: "style.css", content: "text/css"}) != nil ||
+		mux.AssetFile(&AssetsTemplate{path: "other.png", content: "image/png"}) != nil {
+		t.Fatal("ERROR")
+	}
+	// AssetsDir より上位のディレクトリは参照しない
+	if render, ok := mux.AssetFile(&AssetsTemplate{path: "../../image.png", content: "image/png"}).(*basemux.FileView); !ok ||
+		render.Name != filepath.Join(dir, "image.png") {
+		t.Fatal("ERROR")
+	}
+
+	// バイナリファイルは、Range リクエストに対応して送信する
+	render := mux.AssetFile(&AssetsTemplate{path: "image.png", content: "image/png"})
+	if render == nil {
+		t.Fatal("ERROR")
+	}
+	r := httptest.NewRequest("GET", "/assets/image.png", nil)
+	r.Header.Set("Range", "bytes=2-5")
+	w := httptest.NewRecorder()
+	render.Render(w, r)
+	if w.Code != 206 || w.Body.String() != "2345" || w.Header().Get("Content-Type") != "image/png" || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatal(w.Code, w.Header())
+	}
+}
+
+func Test_Negotiate(t *testing.T) {
+	mux := &Mux{Encoders: encoder.Defaults()}
+	negotiate := func(accept, format string, formats ...string) (*RenderTemplate, http.Header, error) {
+		r := httptest.NewRequest("GET", "/", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		render := (&RenderTemplate{ext: "html", content: "text/html"}).Negotiate(formats...)
+		err := mux.negotiate(w, r, render, format)
+		return render, w.Header(), err
+	}
+
+	// Accept ヘッダがない場合、ブラウザからのアクセスの場合は HTML を出力する
+	if r, header, err := negotiate("", ""); err != nil || r.ext != "html" || header.Get("Vary") != "Accept" {
+		t.Fatal(r, err)
+	}
+	if r, _, err := negotiate("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", ""); err != nil || r.ext != "html" {
+		t.Fatal(r, err)
+	}
+	// 優先度の高い形式を出力する
+	if r, _, err := negotiate("application/json", ""); err != nil || r.ext != "json" || r.content != "application/json" {
+		t.Fatal(r, err)
+	}
+	if r, _, err := negotiate("text/*;q=0.5, application/xml", ""); err != nil || r.ext != "xml" {
+		t.Fatal(r, err)
+	}
+	if r, _, err := negotiate("text/*", "", "json", "xml"); err != nil || r.ext != "xml" || r.content != "text/xml" {
+		t.Fatal(r, err)
+	}
+	// 拡張子で指定された形式は、Accept ヘッダより優先する
+	if r, header, err := negotiate("text/html", "json"); err != nil || r.ext != "json" || header.Get("Vary") != "" {
+		t.Fatal(r, err)
+	}
+	// 該当する形式がない場合は、NotAcceptable エラーとする
+	if _, _, err := negotiate("image/png", ""); err == nil {
+		t.Fatal("ERROR")
+	} else if e, ok := err.(*NotAcceptable); !ok || e.StatusCode != 406 {
+		t.Fatal(err)
+	}
+	if _, _, err := negotiate("", "xml", "html", "json"); err == nil {
+		t.Fatal("ERROR")
+	}
+	if _, _, err := negotiate("application/json;q=0", "", "json"); err == nil {
+		t.Fatal("ERROR")
+	}
+
+	// Mux.Encoders に登録された形式も選択できる
+	if r, _, err := negotiate("text/csv", "", "html", "csv"); err != nil || r.ext != "csv" {
+		t.Fatal(r, err)
+	}
+	if _, _, err := negotiate("", "", "html", "unknown"); err == nil {
+		t.Fatal("ERROR")
+	}
+	if r := (&RenderTemplate{}).Format("json"); r.ext != "json" || r.content != "application/json" {
+		t.Fatal(r)
+	}
+
+	// クエリパスの拡張子で形式を指定できるアクション
+	prepost := &PrePost{}
+	if prepost.pathFormat("Index") {
+		t.Fatal("ERROR")
+	}
+	prepost.PathFormat("Index")
+	if prepost.pathFormat("Index") == false || prepost.pathFormat("Show") {
+		t.Fatal(prepost.paths)
+	}
+	prepost.PathFormat()
+	if prepost.pathFormat("Show") == false {
+		t.Fatal(prepost.paths)
+	}
+
+	// クエリパスの拡張子から形式を取り出す
+	if path, format := mux.pathFormat("/users/1.json"); path != "/users/1" || format != "json" {
+		t.Fatal(path, format)
+	}
+	if path, format := mux.pathFormat("/users.csv"); path != "/users" || format != "csv" {
+		t.Fatal(path, format)
+	}
+	if path, format := mux.pathFormat("/users/1.html"); path != "" || format != "" {
+		t.Fatal(path, format)
+	}
+	if path, format := mux.pathFormat("/v1.json/users"); path != "" || format != "" {
+		t.Fatal(path, format)
+	}
+}
+
+func Test_Marshal(t *testing.T) {
+	// マップ以外のデータも JSON に変換する
+	if buf, err := marshalJSON([]int{1, 2}, false, true); err != nil || string(buf) != "[1,2]" {
+		t.Fatal(string(buf), err)
+	}
+	if buf, err := marshalJSON("text", false, true); err != nil || string(buf) != `"text"` {
+		t.Fatal(string(buf), err)
+	}
+	if buf, err := marshalJSON(map[string]int{"a": 1}, true, true); err != nil || string(buf) != "{\n  \"a\": 1\n}" {
+		t.Fatal(string(buf), err)
+	}
+	if buf, err := marshalJSON("<a>", false, true); err != nil || string(buf) != `"\u003ca\u003e"` {
+		t.Fatal(string(buf), err)
+	}
+	if buf, err := marshalJSON("<a>", false, false); err != nil || string(buf) != `"<a>"` {
+		t.Fatal(string(buf), err)
+	}
+
+	// JSONP
+	if buf, err := jsonp("app.callback", []byte("[1]")); err != nil || string(buf) != "/**/app.callback([1]);" {
+		t.Fatal(string(buf), err)
+	}
+	if _, err := jsonp("alert(1)//", []byte("[1]")); err == nil {
+		t.Fatal("ERROR")
+	}
+
+	// スライスの XML はルート要素で囲む
+	type Item struct {
+		Name string `xml:"name"`
+	}
+	if buf, err := marshalXML([]Item{{"a"}, {"b"}}, false, ""); err != nil || string(buf) != "<items><Item><name>a</name></Item><Item><name>b</name></Item></items>" {
+		t.Fatal(string(buf), err)
+	}
+	if buf, err := marshalXML([]Item{{"a"}}, true, "list"); err != nil || string(buf) != "<list>\n  <Item>\n    <name>a</name>\n  </Item>\n</list>" {
+		t.Fatal(string(buf), err)
+	}
+
+	// 変換に失敗した場合は、失敗したデータの位置を返却する
+	if _, err := marshalJSON(map[string]interface{}{"a": []interface{}{1, func() {}}}, false, true); err == nil {
+		t.Fatal("ERROR")
+	} else if e, ok := err.(*MarshalError); !ok || e.Path != "a[1]" {
+		t.Fatal(err)
+	}
+	type User struct {
+		Name  string        `json:"name"`
+		Items []interface{} `json:"items"`
+	}
+	if _, err := marshalJSON([]User{{Name: "a", Items: []interface{}{make(chan int)}}}, false, true); err == nil {
+		t.Fatal("ERROR")
+	} else if e, ok := err.(*MarshalError); !ok || e.Path != "[0].items[0]" {
+		t.Fatal(err)
+	}
+	// 循環参照しているデータも、位置を特定してエラーを返却する
+	cycle := map[string]interface{}{"name": "a"}
+	cycle["self"] = []interface{}{cycle}
+	if _, err := marshalJSON(cycle, false, true); err == nil {
+		t.Fatal("ERROR")
+	} else if e, ok := err.(*MarshalError); !ok || e.Path != "self[0]" {
+		t.Fatal(err)
+	}
+	if _, err := marshalXML(map[string]int{"a": 1}, false, ""); err == nil {
+		t.Fatal("ERROR")
+	} else if _, ok := err.(*MarshalError); !ok {
+		t.Fatal(err)
+	}
+}
+
+type problemTrigger struct {
+	BaseTrigger
+}
+
+func (t problemTrigger) CustomProblem(problem *Problem, status *ErrorStatus) {
+	problem.Type = "https://example.com/problems/" + status.StatusName
+	problem.Set("code", status.StatusName)
+}
+
+// ProblemTrigger を実装していないトリガ
+type plainTrigger struct {
+	Trigger
+}
+
+func Test_Problem(t *testing.T) {
+	mux := &Mux{Trigger: &BaseTrigger{}}
+	format := func(accept string, values map[string]interface{}) (string, http.Header) {
+		r := httptest.NewRequest("GET", "/api/users", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		return mux.errorFormat(w, r, basemux.NewValues("id", values, nil)), w.Header()
+	}
+
+	// ブラウザからのアクセスの場合は HTML を出力する
+	if f, header := format("", nil); f != "html" || header.Get("Vary") != "Accept" {
+		t.Fatal(f, header)
+	}
+	if f, _ := format("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", nil); f != "html" {
+		t.Fatal(f)
+	}
+	if f, _ := format("*/*", nil); f != "html" {
+		t.Fatal(f)
+	}
+	// Accept ヘッダに応じた形式を出力する
+	if f, _ := format("application/problem+json", nil); f != "json" {
+		t.Fatal(f)
+	}
+	if f, _ := format("application/json", nil); f != "json" {
+		t.Fatal(f)
+	}
+	if f, _ := format("application/xml", nil); f != "xml" {
+		t.Fatal(f)
+	}
+	// アクションで宣言された形式、拡張子で指定された形式は Accept ヘッダより優先する
+	if f, header := format("text/html", map[string]interface{}{"errorformat": "json"}); f != "json" || header.Get("Vary") != "" {
+		t.Fatal(f, header)
+	}
+	if f, _ := format("text/html", map[string]interface{}{"format": "xml"}); f != "xml" {
+		t.Fatal(f)
+	}
+	if f, _ := format("", map[string]interface{}{"format": "csv"}); f != "html" {
+		t.Fatal(f)
+	}
+
+	// アクション毎のエラー発生時の形式
+	prepost := &PrePost{}
+	prepost.SetErrorFormat("json")
+	prepost.SetErrorFormat("html", "Index")
+	if prepost.errorFormat("Show") != "json" || prepost.errorFormat("Index") != "html" {
+		t.Fatal(prepost.formats)
+	}
+
+	// RFC 7807 形式で出力する
+	r := httptest.NewRequest("GET", "/api/users/1", nil)
+	status := &ErrorStatus{StatusCode: 404, StatusName: "NotFound", Message: "user not found", r: r}
+	w := httptest.NewRecorder()
+	if err := mux.writeProblem(w, "json", mux.Problem(status, r)); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 404 || w.Header().Get("Content-Type") != "application/problem+json" ||
+		w.Body.String() != `{"detail":"user not found","instance":"/api/users/1","status":404,"title":"Not Found","type":"about:blank"}` {
+		t.Fatal(w.Code, w.Header(), w.Body.String())
+	}
+
+	// ProblemTrigger を実装していないトリガの場合は、項目を変更しない
+	mux.Trigger = plainTrigger{problemTrigger{}}
+	if problem := mux.Problem(status, r); problem.Type != "about:blank" || problem.Extensions != nil {
+		t.Fatal(problem)
+	}
+
+	// トリガで項目を変更、追加する
+	mux.Trigger = problemTrigger{}
+	problem := mux.Problem(status, r)
+	problem.Set("title", "ignored")
+	w = httptest.NewRecorder()
+	if err := mux.writeProblem(w, "json", problem); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != `{"code":"NotFound","detail":"user not found","instance":"/api/users/1","status":404,"title":"Not Found","type":"https://example.com/problems/NotFound"}` {
+		t.Fatal(w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	if err := mux.writeProblem(w, "xml", problem); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("Content-Type") != "application/problem+xml" ||
+		w.Body.String() != xml.Header+`<problem xmlns="urn:ietf:rfc:7807"><type>https://example.com/problems/NotFound</type><title>Not Found</title><status>404</status><detail>user not found</detail><instance>/api/users/1</instance><code>NotFound</code></problem>` {
+		t.Fatal(w.Body.String())
+	}
+}
+
+func Test_Middleware(t *testing.T) {
+	var order []string
+	middleware := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w http.ResponseWriter, r *http.Request, v *Values) interface{} {
+				order = append(order, name)
+				return next(w, r, v)
+			}
+		}
+	}
+	mux := &Mux{}
+	mux.BaseURL = "/baseurl"
+	mux.Use(middleware("global1"), middleware("global2"))
+	mux.UsePath("/api/", middleware("api"))
+	mux.UsePath("/api/users", middleware("users"))
+	mux.UseAction("Users.Show", middleware("action"))
+
+	action := func(w http.ResponseWriter, r *http.Request, v *Values) interface{} {
+		order = append(order, "action")
+		return nil
+	}
+	run := func(path string) []string {
+		order = nil
+		trimpath, _ := mux.trimBaseURL(path)
+		chain(mux.middlewares.lookup(trimpath), action)(nil, httptest.NewRequest("GET", path, nil), nil)
+		return order
+	}
+
+	// 登録した順に実行する
+	if v := strings.Join(run("/baseurl/api/users/1"), ","); v != "global1,global2,api,users,action" {
+		t.Fatal(v)
+	}
+	if v := strings.Join(run("/baseurl/api"), ","); v != "global1,global2,api,action" {
+		t.Fatal(v)
+	}
+	if v := strings.Join(run("/baseurl/apis"), ","); v != "global1,global2,action" {
+		t.Fatal(v)
+	}
+	if v := strings.Join(run("/baseurl"), ","); v != "global1,global2,action" {
+		t.Fatal(v)
+	}
+	if len(mux.middlewares.action("Users.Show")) != 1 || mux.middlewares.action("Users.Index") != nil {
+		t.Fatal(mux.middlewares.actions)
+	}
+
+	// BaseURL を除いたパス
+	if path, ok := mux.trimBaseURL("/baseurl/api"); !ok || path != "/api" {
+		t.Fatal(path, ok)
+	}
+	if _, ok := mux.trimBaseURL("/api"); ok {
+		t.Fatal("ERROR")
+	}
+
+	// 後続の処理を呼び出さずに、復帰値を返却する
+	deny := func(next Handler) Handler {
+		return func(w http.ResponseWriter, r *http.Request, v *Values) interface{} {
+			return &Unauthorized{Message: "unauthorized", StatusCode: 401}
+		}
+	}
+	order = nil
+	if _, ok := chain([]Middleware{middleware("global"), deny}, action)(nil, nil, nil).(*Unauthorized); !ok || strings.Join(order, ",") != "global" {
+		t.Fatal(order)
+	}
+
+	// net/http 形式のミドルウェア。アクションの復帰値は、ミドルウェアが包んだ http.ResponseWriter へ出力する
+	var status, size int
+	logging := mux.HTTPMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Frame-Options", "DENY")
+			lw := &loggingWriter{ResponseWriter: w}
+			next.ServeHTTP(lw, r.WithContext(context.WithValue(r.Context(), "key", "value")))
+			status, size = lw.status, lw.size
+		})
+	})
+	w := httptest.NewRecorder()
+	v := basemux.NewValues("id", nil, nil)
+	result := chain([]Middleware{logging}, func(w http.ResponseWriter, r *http.Request, v *Values) interface{} {
+		return &Render{Buffer: []byte(r.Context().Value("key").(string)), StatusCode: 201, ContentType: "text/plain"}
+	})(w, httptest.NewRequest("GET", "/", nil), v)
+	if _, ok := result.(*written); !ok || w.Code != 201 || w.Body.String() != "value" || w.Header().Get("X-Frame-Options") != "DENY" {
+		t.Fatal(result, w.Code, w.Body.String(), w.Header())
+	}
+	if status != 201 || size != 5 {
+		t.Fatal(status, size)
+	}
+	forbidden := mux.HTTPMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "forbidden", 403)
+		})
+	})
+	w = httptest.NewRecorder()
+	result = chain([]Middleware{forbidden}, action)(w, httptest.NewRequest("GET", "/", nil), v)
+	if _, ok := result.(*written); !ok || w.Code != 403 {
+		t.Fatal(result, w.Code)
+	}
+	// 変更されたセッションは、ミドルウェアを重ねた場合も出力前に一度のみ保存する
+	mux.Session = &session.Config{}
+	mux.Session.Init()
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	v = basemux.NewValues("id", nil, nil)
+	mux.LoadSession(r, v).Set("user", "name")
+	result = chain([]Middleware{logging, logging}, func(w http.ResponseWriter, r *http.Request, v *Values) interface{} {
+		return &Render{Buffer: []byte("body"), StatusCode: 200, ContentType: "text/plain"}
+	})(w, r, v)
+	mux.saveSession(w, v)
+	if _, ok := result.(*written); !ok || len(w.Result().Cookies()) != 1 || w.Body.String() != "body" {
+		t.Fatal(result, w.Header())
+	}
+	// next を呼び出さず、何も書き込まない場合はエラー
+	empty := mux.HTTPMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	})
+	result = chain([]Middleware{empty}, action)(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), v)
+	if _, ok := result.(*InvalidReturn); !ok {
+		t.Fatal(result)
+	}
+}
+
+// ステータスコードと書き込んだサイズを記録する http.ResponseWriter
+type loggingWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (lw *loggingWriter) WriteHeader(code int) {
+	lw.status = code
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+func (lw *loggingWriter) Write(b []byte) (int, error) {
+	n, err := lw.ResponseWriter.Write(b)
+	lw.size += n
+	return n, err
+}
+
+type testRoutes [][]string
+
+func (r *testRoutes) Register(method, path, name string) {
+	*r = append(*r, []string{method, path, name})
+}
+
+func Test_Group(t *testing.T) {
+	var routes testRoutes
+	mux := &Mux{}
+	admin := mux.Group("/admin/", &routes)
+	admin.Register("GET", "/", "Admin.Index")
+	admin.Register("GET", "/users/{id}", "Admin.User")
+	users := admin.Group("users")
+	users.Register("POST", "", "Admin.Create")
+	mux.Group("", &routes).Register("GET", "/", "Public.Index")
+	if v := fmt.Sprint(routes); v != "[[GET /admin Admin.Index] [GET /admin/users/{id} Admin.User] [POST /admin/users Admin.Create] [GET / Public.Index]]" {
+		t.Fatal(v)
+	}
+
+	// ルートグループ配下にのみ、ミドルウェアを適用する
+	admin.Use(func(next Handler) Handler { return next })
+	users.Use(func(next Handler) Handler { return next })
+	if len(mux.middlewares.lookup("/admin/users/1")) != 2 || len(mux.middlewares.lookup("/admin")) != 1 || len(mux.middlewares.lookup("/")) != 0 {
+		t.Fatal(mux.middlewares.paths)
+	}
+
+	// ルートグループ配下にのみ、IP制限を適用する。Mux.RestrictIP の登録順に関わらず、ルートグループのIP制限も判定する
+	mux.RestrictIP = RestrictIP{{IsAllow: true, Path: "/", Addr: []string{"all"}}}
+	admin.Allow("192.168.0.0/24")
+	admin.Deny("all")
+	users.Deny("192.168.0.2")
+	if err := mux.RestrictIP.MakeIPNet(); err != nil {
+		t.Fatal(err)
+	}
+	for _, iplist := range mux.groupIP {
+		if err := iplist.MakeIPNet(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !mux.allowIP("/admin/users", "192.168.0.1") || mux.allowIP("/admin/users", "10.0.0.1") || mux.allowIP("/admin/users", "192.168.0.2") ||
+		!mux.allowIP("/admin", "192.168.0.2") || !mux.allowIP("/", "10.0.0.1") || !mux.allowIP("/administrator", "10.0.0.1") {
+		t.Fatal(mux.groupIP)
+	}
+	if v := len(mux.restrictIP("/admin/users/1")); v != 4 {
+		t.Fatal(v)
+	}
+
+	// プレフィックスに応じて振り分ける
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		})
+	}
+	mount := &MountHandler{entries: []*mountEntry{
+		{prefix: "/", handler: handler("public")},
+		{prefix: "/admin", handler: handler("admin")},
+		{prefix: "/admin/api", handler: handler("api")},
+	}}
+	mount.sort()
+	for path, name := range map[string]string{
+		"/":               "public",
+		"/administrator":  "public",
+		"/admin":          "admin",
+		"/admin/users":    "admin",
+		"/admin/api/user": "api",
+	} {
+		w := httptest.NewRecorder()
+		mount.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Body.String() != name {
+			t.Fatal(path, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	(&MountHandler{entries: []*mountEntry{{prefix: "/admin", handler: handler("admin")}}}).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 404 {
+		t.Fatal(w.Code)
+	}
+
+	// マウントしたすべての Mux を停止する
+	public, private := &Mux{}, &Mux{}
+	public.Handler, private.Handler = &Mux{}, &Mux{}
+	for _, m := range []*Mux{public, private} {
+		if _, err := m.GenerateHandler(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mount = &MountHandler{entries: []*mountEntry{{prefix: "/", mux: public}, {prefix: "/admin", mux: private}}}
+	if err := mount.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mount.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Reverse(t *testing.T) {
+	// router.Routes への登録内容から URL を生成する
+	r := NewRouteTable(router.New())
+	r.AddClass(Example{})
+	r.AddRegexp("id", `[0-9]+`)
+	r.AddRegexp("static", `(.+\.[a-zA-Z0-9_]+)`)
+	r.Register("GET", "/", "Example.Hello")
+	r.Register("POST", "/world", "Example.World")
+	r.Register("GET", "/world/:id", "Example.World")
+	r.Register("GET", "/assets/:static", "Serve.File")
+	r.Register("GET", "/users/:id/posts/:post", "Users.Post")
+	mux := &Mux{
+		RouteTable: r,
+		Tables:     r.TableList(),
+	}
+	mux.BaseURL = "/baseurl"
+	for _, v := range []struct {
+		name   string
+		args   []interface{}
+		result string
+	}{
+		{"Example.Hello", nil, "/baseurl"},
+		{"Example.World", []interface{}{1}, "/baseurl/world/1"},
+		{"Example.World", nil, "/baseurl/world"},
+		{"Serve.File", []interface{}{"stylesheets/application.css"}, "/baseurl/assets/stylesheets/application.css"},
+		{"Users.Post", []interface{}{1, "a b"}, "/baseurl/users/1/posts/a%20b"},
+	} {
+		if url, err := mux.URLFor(v.name, v.args...); err != nil || url != v.result {
+			t.Fatal(v.name, url, err)
+		}
+	}
+
+	// パラメータがパターンと一致しない場合はエラーとする
+	for _, v := range []struct {
+		name string
+		args []interface{}
+	}{
+		{"Example.Unknown", nil},
+		{"Example.World", []interface{}{1, 2}},
+		{"Example.World", []interface{}{"abc"}},
+		{"Users.Post", []interface{}{1, "a/b"}},
+		{"Users.Post", []interface{}{1, ""}},
+	} {
+		if _, err := mux.URLFor(v.name, v.args...); err == nil {
+			t.Fatal(v.name, v.args)
+		} else if e, ok := err.(*ReverseError); !ok || e.Name != v.name {
+			t.Fatal(err)
+		}
+	}
+
+	// コントローラからのリダイレクト
+	c := mux.controller(nil, httptest.NewRequest("GET", "/", nil), nil, "Example", "Hello", nil)
+	if r := c.RedirectTo("Example.World", 10).Perm(); r.err != nil || r.path != "/world/10" || r.statuscode != 301 {
+		t.Fatal(r)
+	}
+	if r := c.RedirectTo("Example.World", "x"); r.err == nil {
+		t.Fatal(r)
+	}
+
+	// RouteTable を使用しない場合は、Tables の登録内容から URL を生成する
+	mux = &Mux{
+		Tables: map[string][][]string{
+			"GET":  {{"/", "Example.Hello"}, {"/world/:id", "Example.World"}},
+			"POST": {{"/world", "Example.World"}},
+		},
+	}
+	mux.BaseURL = "/baseurl"
+	for _, v := range []struct {
+		name   string
+		args   []interface{}
+		result string
+	}{
+		{"Example.Hello", nil, "/baseurl"},
+		{"Example.World", []interface{}{"abc"}, "/baseurl/world/abc"},
+		{"Example.World", nil, "/baseurl/world"},
+	} {
+		if url, err := mux.URLFor(v.name, v.args...); err != nil || url != v.result {
+			t.Fatal(v.name, url, err)
+		}
+	}
+	if _, err := mux.URLFor("Example.World", "a/b"); err == nil {
+		t.Fatal("a/b")
+	}
+}
+
+type Routing struct {
+	*Controller
+}
+
+func (c *Routing) PrePostRegister(prepost *PrePost) {
+	prepost.SetTimeout(30, "Show")
+}
+
+func (c *Routing) Index() Result {
+	return c.Render().HTML()
+}
+
+func (c *Routing) Show(id string) Result {
+	return c.Render().HTML()
+}
+
+func Test_Routes(t *testing.T) {
+	table := NewRouteTable(router.New())
+	table.AddClass(Routing{})
+	table.AddRegexp("id", `[0-9]+`)
+	table.Register("GET", "/users", "Routing.Index")
+	table.Register("GET", "/users/:id", "Routing.Show")
+	table.Register("GET", "/users/new", "Routing.New")
+	table.Register("GET", "/admin/:name", "Routing.Index")
+	mux := &Mux{
+		Router: testRouter{
+			"GET /users":   {name: "Routing.Index"},
+			"GET /users/0": {name: "Routing.Show"},
+			"GET /admin/0": {name: "Routing.Index", valid: &router.NotEnoughArgs{Message: "'Routing.Index' takes 0 arguments"}},
+		},
+		RouteTable: table,
+		RestrictIP: RestrictIP{{IsAllow: false, Path: "/admin", Addr: []string{"all"}}},
+		RoutesPath: "/_routes",
+	}
+	mux.BaseURL = "/baseurl"
+	mux.Timeout = 60
+	mux.Charset = "UTF-8"
+
+	var routes = make(map[string]*RouteInfo)
+	for _, r := range mux.Routes() {
+		routes[r.Path] = r
+	}
+	if r := routes["/baseurl/users/:id"]; r == nil || r.Method != "GET" || r.Name != "Routing.Show" || r.Params["id"] != "[0-9]+" || r.Timeout != 30 || r.RestrictIP != nil {
+		t.Fatal(r)
+	}
+	if r := routes["/baseurl/users"]; r == nil || r.Timeout != 60 || r.Params != nil {
+		t.Fatal(r)
+	}
+	if r := routes["/baseurl/admin/:name"]; r == nil || len(r.RestrictIP) != 1 || r.Params["name"] != "" {
+		t.Fatal(r)
+	}
+
+	// 存在しないアクション、引数の数が一致しないアクションを検出する
+	problems := strings.Join(mux.CheckRoutes(), "\n")
+	if !strings.Contains(problems, "(Routing.New): NotRoutes") || !strings.Contains(problems, "(Routing.Index): NotEnoughArguments: 'Routing.Index' takes 0 arguments") {
+		t.Fatal(problems)
+	}
+	// 正規表現に一致しない場合は、重複とみなさない
+	if strings.Contains(problems, "overlaps") {
+		t.Fatal(problems)
+	}
+	delete(table.regexps, "id")
+	if problems := strings.Join(mux.CheckRoutes(), "\n"); !strings.Contains(problems, "'GET /users/:id' (Routing.Show) overlaps 'GET /users/new' (Routing.New)") {
+		t.Fatal(problems)
+	}
+
+	// テキスト、JSON で出力する
+	var buf bytes.Buffer
+	if err := mux.WriteRoutes(&buf, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`GET +/baseurl/users/:id +Routing.Show +id= +30s`).MatchString(buf.String()) ||
+		!regexp.MustCompile(`GET +/baseurl/admin/:name +Routing.Index +name= +60s +deny all \(/admin\)`).MatchString(buf.String()) {
+		t.Fatal(buf.String())
+	}
+
+	// ルート一覧を出力するクエリパスへのアクセス
+	handler := chain([]Middleware{mux.routesEndpoint()}, func(w http.ResponseWriter, r *http.Request, v *Values) interface{} {
+		return nil
+	})
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/baseurl/_routes?format=json", nil)
+	if _, ok := handler(w, r, nil).(*written); !ok || w.Header().Get("Content-Type") != "application/json; charset=UTF-8" {
+		t.Fatal(w.Header())
+	}
+	var data struct {
+		Routes   []*RouteInfo
+		Problems []string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil || len(data.Routes) != 4 || len(data.Problems) != 3 {
+		t.Fatal(w.Body.String(), err)
+	}
+	if result := handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/baseurl/users", nil), nil); result != nil {
+		t.Fatal(result)
+	}
+
+	// IPv6 のアクセス元でもIP制限を判定する
+	mux.RestrictIP = RestrictIP{{IsAllow: false, Path: "/_routes", Addr: []string{"::1/128"}}}
+	if err := mux.RestrictIP.MakeIPNet(); err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest("GET", "/baseurl/_routes", nil)
+	r.RemoteAddr = "[::1]:8080"
+	if addr := remoteAddr(r); addr != "::1" {
+		t.Fatal(addr)
+	}
+	if result := handler(httptest.NewRecorder(), r, nil); result != nil {
+		t.Fatal(result)
+	}
+	r.RemoteAddr = "[::2]:8080"
+	if _, ok := handler(httptest.NewRecorder(), r, nil).(*written); !ok {
+		t.Fatal(r.RemoteAddr)
+	}
+
+	// RouteTable を使用せず、Router、Tables を設定した場合は、Tables の登録内容を出力する
+	mux = &Mux{
+		Router: testRouter{
+			"GET /users":   {name: "Routing.Index"},
+			"GET /users/0": {name: "Routing.Show", valid: &router.NotEnoughArgs{Message: "'Routing.Show' takes 1 arguments"}},
+		},
+		Tables: map[string][][]string{
+			"GET": {{"/users", "Routing.Index"}, {"/users/:id", "Routing.Show"}},
+		},
+		RoutesPath: "/_routes",
+	}
+	mux.Timeout = 60
+	if routes := mux.Routes(); len(routes) != 2 || routes[1].Path != "/users/:id" || routes[1].Name != "Routing.Show" || routes[1].Params["id"] != "" || routes[1].Timeout != 60 {
+		t.Fatal(routes)
+	}
+	if problems := strings.Join(mux.CheckRoutes(), "\n"); !strings.Contains(problems, "(Routing.Show): NotEnoughArguments") {
+		t.Fatal(problems)
+	}
+	buf.Reset()
+	if err := mux.WriteRoutes(&buf, "text"); err != nil || !regexp.MustCompile(`GET +/users/:id +Routing.Show +id= +60s`).MatchString(buf.String()) {
+		t.Fatal(buf.String(), err)
+	}
+	handler = chain([]Middleware{mux.routesEndpoint()}, func(w http.ResponseWriter, r *http.Request, v *Values) interface{} {
+		return nil
+	})
+	w = httptest.NewRecorder()
+	if _, ok := handler(w, httptest.NewRequest("GET", "/_routes", nil), nil).(*written); !ok || !strings.Contains(w.Body.String(), "Routing.Show") {
+		t.Fatal(w.Body.String())
+	}
+}
+
+// ルーティングテーブルのテスト用実装。"メソッド名 クエリパス" に該当するアクションを返却する
+type testRouter map[string]*testResult
+
+func (r testRouter) Caller(method, path string) (router.Result, []reflect.Value, error) {
+	res, ok := r[method+" "+path]
+	if !ok {
+		return nil, nil, &router.NotRoutes{Message: "'" + path + "' not found", Path: path, Method: method}
+	}
+	return res, res.args, nil
+}
+
+type testResult struct {
+	name  string
+	args  []reflect.Value
+	valid error // Valid が返却するエラー
+}
+
+func (res *testResult) Get() (reflect.Value, error) {
+	return reflect.ValueOf(&Routing{}), nil
+}
+
+func (res *testResult) Name() (string, string) {
+	names := strings.SplitN(res.name, ".", 2)
+	return names[0], names[1]
+}
+
+func (res *testResult) Valid(action reflect.Value, args []reflect.Value, rettype string) (reflect.Value, error) {
+	return action, res.valid
+}
+
+func (res *testResult) Callname(action reflect.Value, name string, args []reflect.Value) ([]reflect.Value, error) {
+	return nil, nil
+}
+
+func Test_ValidateRoutes(t *testing.T) {
+	table := NewRouteTable(router.New())
+	table.AddRegexp("id", `[0-9]+`)
+	table.AddRegexp("static", `(.+\.[a-zA-Z0-9_]+)`)
+	table.Register("GET", "/", "Routing.Index")
+	table.Register("GET", "/users/:id", "Routing.Show")
+	table.Register("GET", "/files/:static", "Routing.Show")
+	table.Register("*", "/any/:name", "Routing.Show")
+	mux := &Mux{RouteTable: table}
+	// ルーティングテーブルが未生成の場合はエラーとする
+	if err := mux.ValidateRoutes(); err == nil {
+		t.Fatal("router is nil")
+	}
+
+	// パラメータは正規表現に一致する文字列に置き換え、ルーティングテーブルからアクションを取得する
+	args := []reflect.Value{reflect.ValueOf("0")}
+	mux.Router = testRouter{
+		"GET /":           {name: "Routing.Index"},
+		"GET /users/0":    {name: "Routing.Show", args: args},
+		"GET /files/0.0":  {name: "Routing.Show", args: args},
+		"* /any/0":        {name: "Routing.Show", args: args},
+		"POST /args/0":    {name: "Invalid.Args", valid: &router.IllegalArgs{Message: "illegal args"}},
+		"POST /rets/0":    {name: "Invalid.Rets", valid: &router.NotEnoughRets{Message: "not enough rets"}},
+		"POST /type/0":    {name: "Invalid.Type", valid: &router.IllegalRets{Message: "illegal rets"}},
+		"POST /count":     {name: "Routing.Show", valid: &router.NotEnoughArgs{Message: "not enough args"}},
+		"POST /nomixin":   {name: "NoMixin.Index", valid: &router.NoMixin{Message: "no mixin"}},
+		"POST /overlap/0": {name: "Routing.Index"},
+	}
+	if err := mux.ValidateRoutes(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 誤りがあるルートをすべて返却する
+	for path, name := range map[string]string{
+		"/args/:id":    "Invalid.Args",
+		"/rets/:id":    "Invalid.Rets",
+		"/type/:id":    "Invalid.Type",
+		"/count":       "Routing.Show",
+		"/nomixin":     "NoMixin.Index",
+		"/unknown":     "Unknown.Index",
+		"/overlap/:id": "Routing.Show",
+	} {
+		table.Register("POST", path, name)
+	}
+	err := mux.ValidateRoutes()
+	errs, ok := err.(RoutesError)
+	if !ok || len(errs) != 5 {
+		t.Fatal(err)
+	}
+	var result = make(map[string]string)
+	for _, e := range errs {
+		result[e.Path] = e.StatusName
+	}
+	for path, status := range map[string]string{
+		"/args/:id":    "IllegalArguments",
+		"/rets/:id":    "NotEnoughReturn",
+		"/type/:id":    "IllegalReturn",
+		"/count":       "NotEnoughArguments",
+		"/nomixin":     "NoMixIn",
+		"/unknown":     "", // アクションを取得できないルートは、エラーとせず警告する
+		"/overlap/:id": "", // 同じクエリパスに該当する別のルートは検証しない
+	} {
+		if result[path] != status {
+			t.Fatal(path, result[path], err)
+		}
+	}
+	if !strings.HasPrefix(err.Error(), "5 invalid routes:\n") {
+		t.Fatal(err)
+	}
+	if problems := strings.Join(mux.CheckRoutes(), "\n"); !strings.Contains(problems, "'POST /unknown' (Unknown.Index): NotRoutes") {
+		t.Fatal(problems)
+	}
+
+	// RouteTable を使用しない場合は、Tables に登録されたルートを検証する
+	mux = &Mux{
+		Router: testRouter{
+			"GET /users/0": {name: "Routing.Show", valid: &router.IllegalArgs{Message: "illegal args"}},
+		},
+		Tables: map[string][][]string{
+			"GET": {{"/users/:id", "Routing.Show"}},
+		},
+	}
+	if errs, ok := mux.ValidateRoutes().(RoutesError); !ok || len(errs) != 1 || errs[0].Path != "/users/:id" || errs[0].StatusName != "IllegalArguments" {
+		t.Fatal(errs)
+	}
+
+	// パラメータは、正規表現に一致し、"/" を含まない文字列に置き換える
+	table = NewRouteTable(router.New())
+	for name, re := range map[string]string{
+		"any":     `.*`,
+		"segment": `[^/]+`,
+		"lower":   `^[a-z]*$`,
+		"digits":  `\d{2,}`,
+		"quest":   `(?:ab)?c`,
+		"symbol":  `[^0-9A-Za-z_\-]+`,
+	} {
+		table.AddRegexp(name, re)
+	}
+	mux = &Mux{RouteTable: table}
+	for param, sample := range map[string]string{
+		"any":     "0",
+		"segment": "0",
+		"lower":   "a",
+		"digits":  "00",
+		"quest":   "abc",
+		"symbol":  "!",
+	} {
+		if path := mux.samplePath(&route{path: "/x/:" + param}); path != "/x/"+sample {
+			t.Fatal(param, path)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package mux
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ochipin/mux/basemux"
+	"github.com/ochipin/mux/encoder"
+)
+
+// Encoder : encoder.Encoder のエイリアス
+type Encoder = encoder.Encoder
+
+// Negotiate で選択可能な形式と、形式に対応する Content-Type
+var formatTypes = map[string][]string{
+	"html": {"text/html"},
+	"text": {"text/plain"},
+	"json": {"application/json"},
+	"xml":  {"application/xml", "text/xml"},
+}
+
+// Auto で選択する形式。Accept ヘッダの優先度が同じ場合は、先頭の形式を優先する
+var autoFormats = []string{"html", "json", "xml"}
+
+// クエリパスの拡張子で指定できる標準の形式 (ex: /users/1.json)
+var pathFormats = map[string]string{
+	".json": "json",
+	".xml":  "xml",
+}
+
+// Auto : Accept ヘッダ、またはクエリパスの拡張子(.json/.xml)から、HTML/JSON/XML のいずれかの形式を選択して出力する
+func (r *RenderTemplate) Auto() *RenderTemplate {
+	return r.Negotiate(autoFormats...)
+}
+
+// Negotiate : 指定した形式(html, text, json, xml)の中から、Accept ヘッダ、またはクエリパスの拡張子に応じた形式を選択して出力する
+// 該当する形式がない場合は、NotAcceptable エラーとなる。クエリパスの拡張子は、PrePost.PathFormat で設定したアクションのみ使用できる
+func (r *RenderTemplate) Negotiate(formats ...string) *RenderTemplate {
+	if len(formats) == 0 {
+		formats = autoFormats
+	}
+	r.formats = formats
+	return r
+}
+
+// 受け付け可能なメディアタイプ
+type mediaRange struct {
+	mediatype string  // メディアタイプ(ex: text)
+	subtype   string  // サブタイプ(ex: html)
+	q         float64 // 優先度
+}
+
+// Accept ヘッダを解析する
+func parseAccept(accept string) []*mediaRange {
+	var result []*mediaRange
+	for _, v := range strings.Split(accept, ",") {
+		params := strings.Split(v, ";")
+		types := strings.SplitN(strings.ToLower(strings.TrimSpace(params[0])), "/", 2)
+		if len(types) != 2 {
+			continue
+		}
+		m := &mediaRange{mediatype: types[0], subtype: types[1], q: 1}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					m.q = f
+				}
+			}
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+// Content-Type の優先度を返却する。最も詳細に一致したメディアタイプの優先度を使用する
+func quality(ranges []*mediaRange, content string) float64 {
+	types := strings.SplitN(content, "/", 2)
+	var q float64
+	var specificity = -1
+	for _, m := range ranges {
+		var s int
+		switch {
+		case m.mediatype == types[0] && m.subtype == types[1]:
+			s = 2
+		case m.mediatype == types[0] && m.subtype == "*":
+			s = 1
+		case m.mediatype == "*" && m.subtype == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = m.q, s
+		}
+	}
+	return q
+}
+
+// 形式に対応する Content-Type を返却する。Mux.Encoders に登録された形式の場合は、変換処理の Content-Type を返却する
+func (mux *Mux) formatTypes(format string) []string {
+	if types, ok := formatTypes[format]; ok {
+		return types
+	}
+	if enc, ok := mux.Encoders[format]; ok && enc != nil {
+		return []string{strings.ToLower(strings.TrimSpace(strings.Split(enc.ContentType, ";")[0]))}
+	}
+	return nil
+}
+
+// 出力形式を決定し、RenderTemplate へ反映する。format にはクエリパスの拡張子で指定された形式を渡す
+func (mux *Mux) negotiate(w http.ResponseWriter, req *http.Request, r *RenderTemplate, format string) error {
+	for _, f := range r.formats {
+		if mux.formatTypes(f) == nil {
+			return fmt.Errorf("'%s' unknown format", f)
+		}
+	}
+
+	var selected, content string
+	// クエリパスの拡張子で形式が指定されている場合は、Accept ヘッダより優先する
+	if format != "" {
+		for _, f := range r.formats {
+			if f == format {
+				selected, content = f, mux.formatTypes(f)[0]
+				break
+			}
+		}
+	} else {
+		// 形式毎にレスポンスが異なるため、Vary を付与する
+		basemux.AddVary(w.Header(), "Accept")
+		accept := req.Header.Get("Accept")
+		if accept == "" {
+			accept = "*/*"
+		}
+		ranges := parseAccept(accept)
+		var max float64
+		for _, f := range r.formats {
+			for _, t := range mux.formatTypes(f) {
+				if q := quality(ranges, t); q > max {
+					selected, content, max = f, t, q
+				}
+			}
+		}
+	}
+
+	if selected == "" {
+		accept := req.Header.Get("Accept")
+		if format != "" {
+			accept = "." + format
+		}
+		return &NotAcceptable{
+			Message:    fmt.Sprintf("'%s' is not acceptable. available formats are %s", accept, strings.Join(r.formats, ", ")),
+			StatusCode: 406,
+			Accept:     accept,
+			Formats:    r.formats,
+		}
+	}
+	r.ext = selected
+	r.content = content
+	return nil
+}
+
+// クエリパスの拡張子から形式を取り出し、拡張子を除いたクエリパスを返却する。該当しない場合は空文字列を返却する
+// .json/.xml の他、Mux.Encoders に登録された形式を拡張子として使用できる (ex: /users.csv)
+func (mux *Mux) pathFormat(path string) (string, string) {
+	idx := strings.LastIndex(path, ".")
+	if idx == -1 || strings.Contains(path[idx:], "/") {
+		return "", ""
+	}
+	ext := strings.ToLower(path[idx:])
+	if format, ok := pathFormats[ext]; ok {
+		return path[:idx], format
+	}
+	if enc, ok := mux.Encoders[ext[1:]]; ok && enc != nil {
+		return path[:idx], ext[1:]
+	}
+	return "", ""
+}
@@ -0,0 +1,228 @@
+package mux
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ochipin/mux/basemux"
+	"github.com/ochipin/mux/helpers"
+)
+
+// マニフェストファイル名
+const manifestName = "manifest.json"
+
+// プリコンパイル済み静的ファイルの Cache-Control
+const immutableCache = "public, max-age=31536000, immutable"
+
+// Manifest : 静的ファイルのパスと、プリコンパイル済みのパスの対応表
+// コントローラ、アクション毎に内容が異なる静的ファイルは "パス@コントローラ名.アクション名" をキーとする
+// ex) {"stylesheets/application.css": "stylesheets/application-0123456789abcdef.css"}
+type Manifest map[string]string
+
+// プリコンパイル済み静的ファイルの管理情報
+type manifest struct {
+	paths Manifest        // 静的ファイルのパスと、プリコンパイル済みのパスの対応表
+	files map[string]bool // プリコンパイル済みのパス一覧
+}
+
+// 対応表から管理情報を生成する
+func newManifest(paths Manifest) *manifest {
+	m := &manifest{
+		paths: paths,
+		files: make(map[string]bool, len(paths)),
+	}
+	for _, path := range paths {
+		m.files[path] = true
+	}
+	return m
+}
+
+// 静的ファイルのパスに該当する、プリコンパイル済みのパスを返却する。該当しない場合は空文字列を返却する
+func (m *manifest) lookup(path, ctlname, actname string) string {
+	if m == nil {
+		return ""
+	}
+	if v, ok := m.paths[path+"@"+ctlname+"."+actname]; ok {
+		return v
+	}
+	return m.paths[path]
+}
+
+// プリコンパイル済みのパスか否かを判定する
+func (m *manifest) compiled(path string) bool {
+	return m != nil && m.files[path]
+}
+
+// LoadManifest : Precompiled ディレクトリのマニフェストを読み込む。マニフェストが存在しない場合は何もしない
+func (mux *Mux) LoadManifest() error {
+	if mux.Precompiled == "" {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(mux.Precompiled, manifestName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var paths Manifest
+	if err := json.Unmarshal(buf, &paths); err != nil {
+		return fmt.Errorf("%s: %s", manifestName, err)
+	}
+	mux.manifest = newManifest(paths)
+	return nil
+}
+
+// Precompile : AssetsDir 配下の静的ファイルを処理し、内容のハッシュ値を付与したファイル名で Precompiled ディレクトリへ出力する
+// actions には "コントローラ名.アクション名" を指定する。指定したアクションから参照した場合に内容が異なる静的ファイルは、アクション毎に出力する
+// 出力後はマニフェストを読み込み、stylesheet/script ヘルパがプリコンパイル済みのパスを出力するようになる
+// New 実行後、サーバ起動前にコールすること
+func (mux *Mux) Precompile(actions ...string) (Manifest, error) {
+	if mux.Precompiled == "" {
+		return nil, fmt.Errorf("'Precompiled' parameter is empty")
+	}
+
+	var paths = make(Manifest)
+	err := filepath.Walk(mux.AssetsDir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// 出力先のディレクトリは対象外とする
+		if info.IsDir() && filepath.Clean(name) == filepath.Clean(mux.Precompiled) {
+			return filepath.SkipDir
+		}
+		// ディレクトリ、隠しファイル、gzip で圧縮済みのファイルは対象外とする
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") || filepath.Ext(name) == ".gz" {
+			return nil
+		}
+		path, err := filepath.Rel(mux.AssetsDir, name)
+		if err != nil {
+			return err
+		}
+		path = filepath.ToSlash(path)
+
+		// 呼び出し元のアクションを持たない状態で処理した内容を、既定のファイルとする
+		buf, err := mux.compileAsset(path, "", "")
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		if paths[path], err = mux.writeAsset(path, buf); err != nil {
+			return err
+		}
+		// アクション毎に処理し、内容が既定のファイルと異なる場合のみ出力する
+		for _, execname := range actions {
+			names := strings.SplitN(execname, ".", 2)
+			if len(names) != 2 {
+				return fmt.Errorf("'%s' invalid action name", execname)
+			}
+			compiled, err := mux.compileAsset(path, names[0], names[1])
+			if err != nil {
+				return fmt.Errorf("%s(%s): %s", path, execname, err)
+			}
+			if string(compiled) == string(buf) {
+				continue
+			}
+			if paths[path+"@"+execname], err = mux.writeAsset(path, compiled); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// マニフェストを出力し、読み込む
+	buf, err := json.MarshalIndent(paths, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(mux.Precompiled, manifestName), buf, 0644); err != nil {
+		return nil, err
+	}
+	mux.manifest = newManifest(paths)
+	return paths, nil
+}
+
+// 呼び出し元のコントローラ名、アクション名を指定して静的ファイルを処理する。言語はデフォルトの言語を使用する
+func (mux *Mux) compileAsset(path, ctlname, actname string) ([]byte, error) {
+	r := &http.Request{Header: make(http.Header)}
+	helper := &helpers.Helpers{
+		Params: helpers.Parameters{
+			"charset":    mux.Charset,
+			"lang":       mux.Locale.Lookup(""),
+			"controller": ctlname,
+			"action":     actname,
+		},
+		MethodName: mux.MethodName,
+		Locale:     mux.Locale,
+		BaseURL:    mux.BaseURL,
+		LangData:   mux.I18n(r, ctlname, actname),
+		Reverse:    mux.reversePath,
+	}
+
+	render := mux.StaticFiles.Copy()
+	if err := render.SmallHelper(mux.Trigger.SetHelper(helper)); err != nil {
+		return nil, err
+	}
+	return render.Render(path, nil)
+}
+
+// 内容のハッシュ値を付与したファイル名で出力し、出力したファイルのパスを返却する
+// ex) stylesheets/application.css => stylesheets/application-0123456789abcdef.css
+func (mux *Mux) writeAsset(path string, buf []byte) (string, error) {
+	sum := sha256.Sum256(buf)
+	ext := filepath.Ext(path)
+	compiled := fmt.Sprintf("%s-%x%s", strings.TrimSuffix(path, ext), sum[:8], ext)
+
+	name := filepath.Join(mux.Precompiled, filepath.FromSlash(compiled))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(name, buf, 0644); err != nil {
+		return "", err
+	}
+	return compiled, nil
+}
+
+// ヘルパに渡す、静的ファイルのURLをプリコンパイル済みのURLへ変換する関数を生成する
+// ex) assets/stylesheets/application.css => assets/stylesheets/application-0123456789abcdef.css
+func (mux *Mux) assetPath(helper *helpers.Helpers) func(string) string {
+	return func(path string) string {
+		if mux.manifest == nil {
+			return ""
+		}
+		// 先頭のディレクトリは、静的ファイルを扱うルートパスとみなす
+		names := strings.SplitN(strings.TrimLeft(path, "/"), "/", 2)
+		if len(names) != 2 {
+			return ""
+		}
+		ctlname, _ := helper.Params["controller"].(string)
+		actname, _ := helper.Params["action"].(string)
+		if compiled := mux.manifest.lookup(names[1], ctlname, actname); compiled != "" {
+			return names[0] + "/" + compiled
+		}
+		return ""
+	}
+}
+
+// Compiled : プリコンパイル済みの静的ファイルを、長期間キャッシュ可能なファイルとして処理する
+func (mux *Mux) Compiled(r *AssetsTemplate) (basemux.Render, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(mux.Precompiled, filepath.FromSlash(r.path)))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(buf)
+	return &Render{
+		Buffer:       buf,
+		StatusCode:   200,
+		ContentType:  r.content,
+		ETag:         fmt.Sprintf(`"%x"`, sum[:16]),
+		CacheControl: immutableCache,
+	}, nil
+}
@@ -0,0 +1,165 @@
+package mux
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"sort"
+
+	"github.com/ochipin/mux/basemux"
+)
+
+// エラー画面の形式と、形式に対応する Content-Type
+var problemTypes = map[string][]string{
+	"html": {"text/html", "application/xhtml+xml"},
+	"json": {"application/problem+json", "application/json"},
+	"xml":  {"application/problem+xml", "application/xml", "text/xml"},
+}
+
+// Accept ヘッダで判定する形式。優先度が同じ場合は、先頭の形式を優先する
+var problemFormats = []string{"html", "json", "xml"}
+
+// Problem : RFC 7807 形式のエラー情報(application/problem+json, application/problem+xml)
+type Problem struct {
+	Type       string                 // エラーの種類を示すURI(未設定の場合は about:blank)
+	Title      string                 // エラーの概要
+	Status     int                    // ステータスコード
+	Detail     string                 // エラーの詳細
+	Instance   string                 // エラーが発生したURI
+	Extensions map[string]interface{} // 追加で出力する項目
+}
+
+// Set : 追加で出力する項目をセットする。type, title, status, detail, instance は上書きできない
+func (p *Problem) Set(key string, val interface{}) {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]interface{})
+	}
+	p.Extensions[key] = val
+}
+
+// 出力する項目を返却する
+func (p *Problem) members() map[string]interface{} {
+	var result = make(map[string]interface{}, len(p.Extensions)+5)
+	for key, val := range p.Extensions {
+		result[key] = val
+	}
+	result["type"] = p.Type
+	result["title"] = p.Title
+	result["status"] = p.Status
+	if p.Detail != "" {
+		result["detail"] = p.Detail
+	} else {
+		delete(result, "detail")
+	}
+	if p.Instance != "" {
+		result["instance"] = p.Instance
+	} else {
+		delete(result, "instance")
+	}
+	return result
+}
+
+// MarshalJSON : 追加の項目を含めて、JSON へ変換する
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.members())
+}
+
+// MarshalXML : 追加の項目を含めて、RFC 7807 Appendix A の形式で XML へ変換する
+func (p *Problem) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start = xml.StartElement{
+		Name: xml.Name{Space: "urn:ietf:rfc:7807", Local: "problem"},
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	members := p.members()
+	var keys []string
+	for key := range members {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// 標準の項目を先頭に出力する
+	for _, key := range []string{"type", "title", "status", "detail", "instance"} {
+		if val, ok := members[key]; ok {
+			if err := e.EncodeElement(val, xml.StartElement{Name: xml.Name{Local: key}}); err != nil {
+				return err
+			}
+			delete(members, key)
+		}
+	}
+	for _, key := range keys {
+		if val, ok := members[key]; ok {
+			if err := e.EncodeElement(val, xml.StartElement{Name: xml.Name{Local: key}}); err != nil {
+				return err
+			}
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// Problem : エラー画面の情報から、RFC 7807 形式のエラー情報を生成する
+// Trigger が ProblemTrigger を実装している場合は、CustomProblem で項目の変更や追加ができる
+func (mux *Mux) Problem(status *ErrorStatus, r *http.Request) *Problem {
+	problem := &Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status.StatusCode),
+		Status:   status.StatusCode,
+		Detail:   status.Message,
+		Instance: r.URL.Path,
+	}
+	if problem.Title == "" {
+		problem.Title = status.Title
+	}
+	if trigger, ok := mux.Trigger.(ProblemTrigger); ok {
+		trigger.CustomProblem(problem, status)
+	}
+	return problem
+}
+
+// エラー画面の形式(html, json, xml)を判定する
+// アクションで宣言された形式、クエリパスの拡張子で指定された形式、Accept ヘッダの順に判定し、該当しない場合は html とする
+func (mux *Mux) errorFormat(w http.ResponseWriter, r *http.Request, v *Values) string {
+	for _, format := range []string{v.Get("errorformat"), v.Get("format")} {
+		if _, ok := problemTypes[format]; ok {
+			return format
+		}
+	}
+
+	// Accept ヘッダによって形式が異なるため、Vary を付与する
+	basemux.AddVary(w.Header(), "Accept")
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return "html"
+	}
+	ranges := parseAccept(accept)
+	var result = "html"
+	var max float64
+	for _, format := range problemFormats {
+		for _, t := range problemTypes[format] {
+			if q := quality(ranges, t); q > max {
+				result, max = format, q
+			}
+		}
+	}
+	return result
+}
+
+// RFC 7807 形式のエラー情報を出力する
+func (mux *Mux) writeProblem(w http.ResponseWriter, format string, problem *Problem) error {
+	var buf []byte
+	var err error
+	if format == "xml" {
+		buf, err = xml.Marshal(problem)
+		buf = append([]byte(xml.Header), buf...)
+	} else {
+		buf, err = json.Marshal(problem)
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", problemTypes[format][0])
+	w.Header().Del("Content-Length")
+	w.WriteHeader(problem.Status)
+	w.Write(buf)
+	return nil
+}
@@ -1,9 +1,13 @@
 package mux
 
+import "fmt"
+
 // Redirect : 301, 302 リダイレクトを実施する構造体
 type Redirect struct {
-	path       string // リダイレクト先のパス
-	statuscode int    // 301, 302 などのステータスコード
+	path       string                 // リダイレクト先のパス
+	statuscode int                    // 301, 302 などのステータスコード
+	flashes    map[string]interface{} // リダイレクト先へ渡す一度きりのデータ
+	err        error                  // リダイレクト先の URL を生成できなかった場合のエラー
 }
 
 // Perm : 301 リダイレクト
@@ -18,4 +22,33 @@ func (r *Redirect) Temp() *Redirect {
 	return r
 }
 
+// With : リダイレクト先でのみ参照できる、一度きりのデータをセットする
+func (r *Redirect) With(key string, val interface{}) *Redirect {
+	if r.flashes == nil {
+		r.flashes = make(map[string]interface{})
+	}
+	r.flashes[key] = val
+	return r
+}
+
+// WithErrors : リダイレクト先でのみ参照できるエラーメッセージを、"errors" をキーにセットする
+// error 型はエラーメッセージに、それ以外の型は文字列に変換する。nil は無視する
+func (r *Redirect) WithErrors(errs ...interface{}) *Redirect {
+	var messages []string
+	for _, v := range errs {
+		switch types := v.(type) {
+		case nil:
+			continue
+		case error:
+			messages = append(messages, types.Error())
+		default:
+			messages = append(messages, fmt.Sprint(types))
+		}
+	}
+	if len(messages) == 0 {
+		return r
+	}
+	return r.With("errors", messages)
+}
+
 func (r *Redirect) pointer() {}
@@ -3,25 +3,86 @@ package mux
 import (
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ochipin/mux/basemux"
 )
 
 // Render : basemux.Render インタフェースに対応したRender構造体
 type Render struct {
-	Buffer      []byte // 表示する内容
-	StatusCode  int    // ステータスコード
-	ContentType string // Content-Type
-	Path        string // リダイレクト先のパス
+	Buffer       []byte    // 表示する内容
+	StatusCode   int       // ステータスコード
+	ContentType  string    // Content-Type
+	Path         string    // リダイレクト先のパス
+	ETag         string    // ETag(ex: "0123abcd")
+	LastModified time.Time // Last-Modified
+	CacheControl string    // Cache-Control
+	Encoding     string    // Content-Encoding。Buffer が圧縮済みの場合に指定する(ex: gzip)
 }
 
 // Render : HTML/TEXT/JSON 等を表示する関数
 func (render *Render) Render(w http.ResponseWriter, r *http.Request) {
 	if render.StatusCode == 301 || render.StatusCode == 302 {
 		http.Redirect(w, r, render.Path, render.StatusCode)
-	} else {
-		w.Header().Set("Content-Type", render.ContentType)
-		w.WriteHeader(render.StatusCode)
-		w.Write(render.Buffer)
+		return
+	}
+
+	// キャッシュに関するヘッダを出力する
+	header := w.Header()
+	if render.CacheControl != "" {
+		header.Set("Cache-Control", render.CacheControl)
+	}
+	if render.ETag != "" {
+		header.Set("ETag", render.ETag)
+	}
+	if render.LastModified.IsZero() == false {
+		header.Set("Last-Modified", render.LastModified.UTC().Format(http.TimeFormat))
 	}
+	// 圧縮済みの内容の場合は、エンコーディング毎にキャッシュされるよう Vary を付与する
+	if render.Encoding != "" {
+		header.Set("Content-Encoding", render.Encoding)
+		basemux.AddVary(header, "Accept-Encoding")
+	}
+	// ブラウザのキャッシュが有効な場合は、304 Not Modified を返却する
+	if render.notModified(r) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Type", render.ContentType)
+	w.WriteHeader(render.StatusCode)
+	w.Write(render.Buffer)
+}
+
+// If-None-Match、If-Modified-Since ヘッダから、ブラウザのキャッシュが有効か否かを判定する
+func (render *Render) notModified(r *http.Request) bool {
+	if render.StatusCode != 200 || (r.Method != "GET" && r.Method != "HEAD") {
+		return false
+	}
+	// If-None-Match が送信された場合は、If-Modified-Since より優先して判定する
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if render.ETag == "" {
+			return false
+		}
+		for _, etag := range strings.Split(match, ",") {
+			etag = strings.TrimSpace(etag)
+			// 弱い比較を行う
+			if etag == "*" || strings.TrimPrefix(etag, "W/") == strings.TrimPrefix(render.ETag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if since := r.Header.Get("If-Modified-Since"); since != "" && render.LastModified.IsZero() == false {
+		t, err := http.ParseTime(since)
+		if err != nil {
+			return false
+		}
+		// Last-Modified は秒単位のため、秒未満を切り捨てて比較する
+		return render.LastModified.Truncate(time.Second).After(t) == false
+	}
+	return false
 }
 
 // RenderTemplate : ビュー情報を管理する構造体
@@ -34,6 +95,11 @@ type RenderTemplate struct {
 	statuscode int         // 2xx, 4xx, 5xx などのエラー値
 	data       interface{} // ビュー内で使用するデータ
 	helper     interface{} // ビュー内で使用する関数
+	formats    []string    // Negotiate で選択可能な形式。nil の場合は形式を選択しない
+	indent     bool        // JSON/XML をインデントして出力するか否か
+	noescape   bool        // JSON 内の '<', '>', '&' をエスケープしないか否か
+	callback   string      // JSONP のコールバック関数名
+	root       string      // スライスを XML で出力する際のルート要素名
 }
 
 // Data : ビュー内で使用するデータ、もしくはJSON/XMLデータを登録する
@@ -95,5 +161,16 @@ func (r *RenderTemplate) XML() *RenderTemplate {
 	return r
 }
 
+// Format : 指定した形式で出力する。html, text, json, xml 以外の形式は、Mux.Encoders に登録された変換処理でデータを変換する
+// ex) Render().Data(rows).Format("csv")
+func (r *RenderTemplate) Format(name string) *RenderTemplate {
+	r.ext = name
+	r.content = ""
+	if types, ok := formatTypes[name]; ok {
+		r.content = types[0]
+	}
+	return r
+}
+
 // mux.Result に対応するための、空メソッド
 func (r *RenderTemplate) pointer() {}
@@ -0,0 +1,199 @@
+package mux
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ochipin/router"
+)
+
+// ReverseError : コントローラ名、アクション名から URL を生成できない場合のエラー
+type ReverseError struct {
+	Message string
+	Name    string // "コントローラ名.アクション名"
+}
+
+func (err *ReverseError) Error() string {
+	return err.Message
+}
+
+// ルーティングテーブルに登録されたルート
+type route struct {
+	method string   // メソッド名
+	path   string   // クエリパスのパターン(ex: /users/:id)
+	name   string   // "コントローラ名.アクション名"
+	params []string // パターンに含まれるパラメータ名
+}
+
+// RouteTable : router.Routes へ登録した内容を記録するルーティングテーブル
+// 記録した内容は、URL の生成(URLFor)、ルート一覧(Routes)、アクションの検証(ValidateRoutes)に使用する
+// RouteTable を使用せず Router、Tables を設定した場合は、Tables の内容を使用する。パラメータのパターンは検証しない
+// ex) r := mux.NewRouteTable(router.New())
+type RouteTable struct {
+	*router.Routes
+	regexps map[string]string // パラメータ名と正規表現
+	classes []interface{}     // 登録したコントローラ
+	routes  []*route          // 登録したルート
+}
+
+// NewRouteTable : router.Routes への登録内容を記録する RouteTable を生成する
+func NewRouteTable(routes *router.Routes) *RouteTable {
+	return &RouteTable{
+		Routes:  routes,
+		regexps: make(map[string]string),
+	}
+}
+
+// AddClass : コントローラを router.Routes へ登録する
+func (t *RouteTable) AddClass(i interface{}) {
+	t.classes = append(t.classes, i)
+	t.Routes.AddClass(i)
+}
+
+// AddRegexp : パラメータ名と正規表現を router.Routes へ登録する
+func (t *RouteTable) AddRegexp(name, re string) {
+	t.regexps[name] = re
+	t.Routes.AddRegexp(name, re)
+}
+
+// Register : ルートを router.Routes へ登録する
+func (t *RouteTable) Register(method, path, name string) {
+	t.routes = append(t.routes, newRoute(method, path, name))
+	t.Routes.Register(method, path, name)
+}
+
+// ルートを生成し、パターンに含まれるパラメータ名を取り出す
+func newRoute(method, path, name string) *route {
+	r := &route{method: method, path: path, name: name}
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, ":") {
+			r.params = append(r.params, segment[1:])
+		}
+	}
+	return r
+}
+
+// Tables に登録されたルートの一覧を返却する
+// Tables は、メソッド名をキーに、[クエリパス, "コントローラ名.アクション名"] の一覧を格納する(router.Routes.TableList の形式)
+func tableRoutes(tables map[string][][]string) []*route {
+	var result []*route
+	for method, rows := range tables {
+		for _, row := range rows {
+			if len(row) < 2 {
+				continue
+			}
+			result = append(result, newRoute(method, row[0], row[1]))
+		}
+	}
+	return result
+}
+
+// パラメータ名に登録された正規表現を返却する
+func (mux *Mux) paramRegexp(param string) (string, bool) {
+	if mux.RouteTable == nil {
+		return "", false
+	}
+	re, ok := mux.RouteTable.regexps[param]
+	return re, ok
+}
+
+// RouteTable に登録したルートの一覧を返却する。RouteTable が未設定の場合は、Tables に登録されたルートを返却する
+// GET を優先し、メソッド名、クエリパスの順に並べる
+func (mux *Mux) routeList() []*route {
+	var result []*route
+	if mux.RouteTable != nil {
+		result = append(result, mux.RouteTable.routes...)
+	} else {
+		result = tableRoutes(mux.Tables)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if (a.method == "GET") != (b.method == "GET") {
+			return a.method == "GET"
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.path < b.path
+	})
+	return result
+}
+
+// コントローラ名、アクション名に該当するルートのパターンへ、パラメータを埋め込んだクエリパスを返却する
+// 該当するルートが複数ある場合は、パラメータ数が一致するルートを使用する
+func (mux *Mux) reversePath(name string, args ...interface{}) (string, error) {
+	var found *route
+	for _, r := range mux.routeList() {
+		if r.name != name {
+			continue
+		}
+		if found == nil || (len(found.params) != len(args) && len(r.params) == len(args)) {
+			found = r
+		}
+	}
+	if found == nil {
+		return "", &ReverseError{Message: fmt.Sprintf("'%s' route not found", name), Name: name}
+	}
+	if len(found.params) != len(args) {
+		return "", &ReverseError{
+			Message: fmt.Sprintf("'%s' (%s) requires %d parameters, but %d given", name, found.path, len(found.params), len(args)),
+			Name:    name,
+		}
+	}
+
+	var segments = strings.Split(found.path, "/")
+	var i int
+	for n, segment := range segments {
+		if strings.HasPrefix(segment, ":") == false {
+			continue
+		}
+		param := segment[1:]
+		value := fmt.Sprint(args[i])
+		i++
+		// 正規表現が登録されている場合は、パラメータが正規表現に一致するか検証する
+		if re, ok := mux.paramRegexp(param); ok {
+			if matched, err := regexp.MatchString(`^(?:`+re+`)$`, value); err != nil || !matched {
+				return "", &ReverseError{
+					Message: fmt.Sprintf("'%s' (%s): '%s' does not match ':%s' pattern '%s'", name, found.path, value, param, re),
+					Name:    name,
+				}
+			}
+		} else if value == "" || strings.Contains(value, "/") {
+			return "", &ReverseError{
+				Message: fmt.Sprintf("'%s' (%s): '%s' is invalid value for ':%s'", name, found.path, value, param),
+				Name:    name,
+			}
+		}
+		// '/' 以外の文字をエスケープする
+		parts := strings.Split(value, "/")
+		for k, part := range parts {
+			parts[k] = url.PathEscape(part)
+		}
+		segments[n] = strings.Join(parts, "/")
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// URLFor : コントローラ名、アクション名("コントローラ名.アクション名")とパラメータから、BaseURL を考慮した URL を生成する
+// ex) mux.URLFor("Example.World", 1) => /baseurl/world/1
+func (mux *Mux) URLFor(name string, args ...interface{}) (string, error) {
+	path, err := mux.reversePath(name, args...)
+	if err != nil {
+		return "", err
+	}
+	return joinBaseURL(mux.BaseURL, path), nil
+}
+
+// BaseURL を考慮したパスに変換する
+// ex) /baseurl + /path/to/url/ => /baseurl/path/to/url
+func joinBaseURL(baseurl, path string) string {
+	// /baseurl/path/to/url/ => baseurl/path/to/url
+	trimpath := strings.Trim(baseurl+"/"+path, "/")
+	// baseurl/path/to/url => [baseurl path to url]
+	paths := strings.Fields(strings.Replace(trimpath, "/", " ", -1))
+	// [baseurl path to url] => /baseurl/path/to/url
+	return "/" + strings.Join(paths, "/")
+}
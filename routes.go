@@ -0,0 +1,239 @@
+package mux
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ochipin/router"
+)
+
+// RouteInfo : ルーティングテーブルに登録されたルートの情報
+type RouteInfo struct {
+	Method     string            // メソッド名
+	Path       string            // BaseURL を含むクエリパスのパターン(ex: /baseurl/users/:id)
+	Name       string            // "コントローラ名.アクション名"
+	Params     map[string]string // パラメータ名と正規表現(AddRegexp で未登録の場合は空文字列)
+	RestrictIP RestrictIP        // ルートに適用されるIP制限
+	Timeout    int               // タイムアウト時間(秒単位)
+}
+
+// Routes : ルーティングテーブルに登録されたルートの一覧を返却する
+// RouteTable が未設定の場合は、Tables に登録されたルートを返却する
+// タイムアウト時間は、RouteTable に登録したコントローラの PrePostRegister で設定した時間を優先する
+func (mux *Mux) Routes() []*RouteInfo {
+	var result []*RouteInfo
+	for _, r := range mux.routeList() {
+		info := &RouteInfo{
+			Method: r.method,
+			Path:   joinBaseURL(mux.BaseURL, r.path),
+			Name:   r.name,
+		}
+		for _, param := range r.params {
+			if info.Params == nil {
+				info.Params = make(map[string]string)
+			}
+			info.Params[param], _ = mux.paramRegexp(param)
+		}
+		// クエリパスに該当するIP制限を抽出する
+		info.RestrictIP = mux.restrictIP(r.path)
+		// アクションにタイムアウト時間が設定されていない場合は、クエリパスのタイムアウト時間とする
+		names := strings.SplitN(r.name, ".", 2)
+		if prepost := mux.prepost(names[0]); prepost != nil {
+			info.Timeout = prepost.timeout(names[1])
+		}
+		if info.Timeout <= 0 {
+			info.Timeout = mux.PathTimeout(info.Path)
+		}
+		result = append(result, info)
+	}
+	return result
+}
+
+// WriteRoutes : ルートの一覧を、指定した形式(text, json)で出力する
+// ex) mux.WriteRoutes(os.Stdout, "text")
+func (mux *Mux) WriteRoutes(w io.Writer, format string) error {
+	routes := mux.Routes()
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{
+			"Routes":   routes,
+			"Problems": mux.CheckRoutes(),
+		})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tPATH\tACTION\tPARAMS\tTIMEOUT\tRESTRICT IP")
+	for _, r := range routes {
+		var params, restrict []string
+		for name, re := range r.Params {
+			params = append(params, name+"="+re)
+		}
+		sort.Strings(params)
+		for _, ip := range r.RestrictIP {
+			rule := "deny"
+			if ip.IsAllow {
+				rule = "allow"
+			}
+			restrict = append(restrict, fmt.Sprintf("%s %s (%s)", rule, strings.Join(ip.Addr, ","), ip.Path))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%ds\t%s\n",
+			r.Method, r.Path, r.Name, strings.Join(params, " "), r.Timeout, strings.Join(restrict, ", "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, problem := range mux.CheckRoutes() {
+		if _, err := fmt.Fprintln(w, "WARNING:", problem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckRoutes : ルーティングテーブルを検証し、問題点の一覧を返却する
+// 同じクエリパスに該当する可能性のあるルート、検証用のクエリパスでアクションを取得できなかったルートと、ValidateRoutes で検出したアクションの問題点を返却する
+func (mux *Mux) CheckRoutes() []string {
+	result := mux.overlaps()
+	if mux.Router == nil {
+		return result
+	}
+	errs, notfound := mux.checkActions()
+	for _, e := range append(notfound, errs...) {
+		result = append(result, e.Error())
+	}
+	return result
+}
+
+// 同じクエリパスに該当する可能性のあるルートを検出する
+func (mux *Mux) overlaps() []string {
+	var result []string
+	routes := mux.routeList()
+	for i, a := range routes {
+		for _, b := range routes[i+1:] {
+			if mux.overlap(a, b) {
+				result = append(result, fmt.Sprintf("'%s %s' (%s) overlaps '%s %s' (%s)", a.method, a.path, a.name, b.method, b.path, b.name))
+			}
+		}
+	}
+	return result
+}
+
+// 2つのルートが、同じリクエストに該当する可能性があるか否かを判定する
+func (mux *Mux) overlap(a, b *route) bool {
+	if a.method != b.method && a.method != "*" && b.method != "*" {
+		return false
+	}
+	sa := strings.Split(strings.TrimRight(a.path, "/"), "/")
+	sb := strings.Split(strings.TrimRight(b.path, "/"), "/")
+	if len(sa) != len(sb) {
+		return false
+	}
+	for i := range sa {
+		pa, pb := strings.HasPrefix(sa[i], ":"), strings.HasPrefix(sb[i], ":")
+		switch {
+		case !pa && !pb:
+			if sa[i] != sb[i] {
+				return false
+			}
+		case pa && !pb:
+			if mux.paramMatch(sa[i][1:], sb[i]) == false {
+				return false
+			}
+		case !pa && pb:
+			if mux.paramMatch(sb[i][1:], sa[i]) == false {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// パラメータの正規表現に、値が一致するか否かを判定する。正規表現が未登録の場合は一致するものとする
+func (mux *Mux) paramMatch(param, value string) bool {
+	re, ok := mux.paramRegexp(param)
+	if !ok {
+		return true
+	}
+	matched, err := regexp.MatchString(`^(?:`+re+`)$`, value)
+	return err != nil || matched
+}
+
+// コントローラ名に該当する、RouteTable の AddClass で登録したコントローラの型を返却する
+func (mux *Mux) controllerType(name string) reflect.Type {
+	if mux.RouteTable == nil {
+		return nil
+	}
+	for _, c := range mux.RouteTable.classes {
+		t := reflect.TypeOf(c)
+		for t != nil && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t != nil && t.Name() == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// RouteTable に登録したコントローラの PrePostRegister をコールし、設定内容を返却する。該当しない場合は nil を返却する
+func (mux *Mux) prepost(ctlname string) (prepost *PrePost) {
+	t := mux.controllerType(ctlname)
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	defer func() {
+		if recover() != nil {
+			prepost = nil
+		}
+	}()
+	// 実行時と同様に、ミックスインされたコントローラを生成する
+	v := reflect.New(t)
+	if err := router.SetStruct(v, &Controller{}); err != nil {
+		return nil
+	}
+	fn := v.MethodByName("PrePostRegister")
+	if !fn.IsValid() {
+		return nil
+	}
+	prepost = &PrePost{}
+	fn.Call([]reflect.Value{reflect.ValueOf(prepost)})
+	return prepost
+}
+
+// ルート一覧を表示する開発用のミドルウェア。RoutesPath へのアクセス時に、ルート一覧を出力する
+// ?format=json、または Accept ヘッダに application/json が含まれる場合は JSON で出力する
+func (mux *Mux) routesEndpoint() Middleware {
+	path := "/" + strings.Trim(mux.RoutesPath, "/")
+	return func(next Handler) Handler {
+		return func(w http.ResponseWriter, r *http.Request, v *Values) interface{} {
+			trimpath, ok := mux.trimBaseURL(r.URL.Path)
+			if !ok || trimpath != path || r.Method != "GET" {
+				return next(w, r, v)
+			}
+			// IP制限がかかっている場合は、後続の処理でエラーとする
+			if mux.allowIP(trimpath, remoteAddr(r)) == false {
+				return next(w, r, v)
+			}
+			format := "text"
+			if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+				format = "json"
+				w.Header().Set("Content-Type", "application/json; charset="+mux.Charset)
+			} else {
+				w.Header().Set("Content-Type", "text/plain; charset="+mux.Charset)
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			if err := mux.WriteRoutes(w, format); err != nil {
+				mux.Log.Error(err)
+			}
+			return &written{}
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// クッキーの最大長
+const maxCookieSize = 4096
+
+// Config : クッキーセッションの設定
+type Config struct {
+	Name     string        // クッキー名(未設定の場合は "session")
+	Path     string        // クッキーのパス(未設定の場合は "/")
+	Domain   string        // クッキーのドメイン
+	MaxAge   int           // クッキーの有効期限(秒単位)。0の場合はブラウザを閉じるまで有効
+	Secure   bool          // HTTPS 接続時のみクッキーを送信するか否か
+	SameSite http.SameSite // SameSite 属性(未設定の場合は Lax)
+	Keys     [][]byte      // 署名鍵。先頭の鍵で署名し、すべての鍵で検証する。未設定の場合は起動毎にランダムな鍵を生成する
+	Encrypt  bool          // セッションデータを暗号化するか否か
+	keys     []*key        // 署名鍵から生成した、署名用と暗号化用の鍵
+}
+
+// 署名用と暗号化用の鍵
+type key struct {
+	sign  []byte
+	block cipher.AEAD
+}
+
+// クッキーに保存するデータの形式
+type payload struct {
+	Time  int64                  `json:"t"`
+	Data  map[string]interface{} `json:"d,omitempty"`
+	Flash map[string]interface{} `json:"f,omitempty"`
+}
+
+// Init : 設定値を検証し、署名用と暗号化用の鍵を生成する
+func (c *Config) Init() error {
+	if c.Name == "" {
+		c.Name = "session"
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.SameSite == 0 {
+		c.SameSite = http.SameSiteLaxMode
+	}
+	// 署名鍵が未設定の場合、ランダムな鍵を生成する
+	if len(c.Keys) == 0 {
+		buf := make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+			return err
+		}
+		c.Keys = [][]byte{buf}
+	}
+
+	c.keys = nil
+	for i, k := range c.Keys {
+		// 短すぎる署名鍵はエラーとする
+		if len(k) < 16 {
+			return fmt.Errorf("session key[%d] must be at least 16 bytes", i)
+		}
+		// 署名鍵から、署名用と暗号化用の鍵をそれぞれ生成する
+		block, err := aes.NewCipher(derive(k, "encrypt"))
+		if err != nil {
+			return err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		c.keys = append(c.keys, &key{
+			sign:  derive(k, "sign"),
+			block: gcm,
+		})
+	}
+	return nil
+}
+
+// 署名鍵から、用途毎の鍵を生成する
+func derive(k []byte, usage string) []byte {
+	mac := hmac.New(sha256.New, k)
+	mac.Write([]byte(usage))
+	return mac.Sum(nil)
+}
+
+// クッキー名と値から署名を生成する
+func (c *Config) sign(k *key, value []byte) []byte {
+	mac := hmac.New(sha256.New, k.sign)
+	mac.Write([]byte(c.Name + "|"))
+	mac.Write(value)
+	return mac.Sum(nil)
+}
+
+// Load : リクエストのクッキーからセッションを復元する。クッキーが存在しない、または不正な場合は空のセッションを返却する
+func (c *Config) Load(r *http.Request) *Session {
+	s := New()
+	cookie, err := r.Cookie(c.Name)
+	if err != nil {
+		return s
+	}
+	p, err := c.decode(cookie.Value)
+	if err != nil {
+		// 不正なクッキーは破棄する
+		s.modified = true
+		return s
+	}
+	if p.Data != nil {
+		s.data = p.Data
+	}
+	// 前回のリクエストでセットされた一度きりのデータは、このリクエストの終了時に破棄する
+	if len(p.Flash) != 0 {
+		s.flashes = p.Flash
+		s.modified = true
+	}
+	return s
+}
+
+// Save : セッションが変更されている場合、Set-Cookie ヘッダを出力する
+func (c *Config) Save(w http.ResponseWriter, s *Session) error {
+	if s.Modified() == false {
+		return nil
+	}
+
+	s.mu.Lock()
+	p := &payload{
+		Time:  time.Now().Unix(),
+		Data:  s.data,
+		Flash: s.next,
+	}
+	// 空のセッションの場合、クッキーを削除する
+	empty := len(s.data) == 0 && len(s.next) == 0
+	value, err := c.encode(p)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	cookie := &http.Cookie{
+		Name:     c.Name,
+		Value:    value,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		MaxAge:   c.MaxAge,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+	}
+	if empty {
+		cookie.Value = ""
+		cookie.MaxAge = -1
+	}
+	if len(cookie.String()) > maxCookieSize {
+		return fmt.Errorf("session cookie size exceeds %d bytes", maxCookieSize)
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// セッションデータを、署名付き(暗号化指定時は暗号化した)文字列へ変換する
+func (c *Config) encode(p *payload) (string, error) {
+	buf, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	k := c.keys[0]
+	// 暗号化指定時は、AES-GCM で暗号化する
+	if c.Encrypt {
+		nonce := make([]byte, k.block.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return "", err
+		}
+		buf = k.block.Seal(nonce, nonce, buf, []byte(c.Name))
+	}
+	return base64.RawURLEncoding.EncodeToString(buf) + "." +
+		base64.RawURLEncoding.EncodeToString(c.sign(k, buf)), nil
+}
+
+// encode で変換した文字列を検証し、セッションデータへ復元する
+func (c *Config) decode(value string) (*payload, error) {
+	idx := strings.LastIndex(value, ".")
+	if idx == -1 {
+		return nil, fmt.Errorf("invalid session cookie")
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(value[:idx])
+	if err != nil {
+		return nil, err
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(value[idx+1:])
+	if err != nil {
+		return nil, err
+	}
+
+	// 登録されているすべての鍵で署名を検証する
+	var k *key
+	for _, v := range c.keys {
+		if hmac.Equal(mac, c.sign(v, buf)) {
+			k = v
+			break
+		}
+	}
+	if k == nil {
+		return nil, fmt.Errorf("invalid session cookie signature")
+	}
+
+	// 暗号化指定時は、復号する
+	if c.Encrypt {
+		size := k.block.NonceSize()
+		if len(buf) < size {
+			return nil, fmt.Errorf("invalid session cookie")
+		}
+		if buf, err = k.block.Open(nil, buf[:size], buf[size:], []byte(c.Name)); err != nil {
+			return nil, err
+		}
+	}
+
+	var p payload
+	if err := json.Unmarshal(buf, &p); err != nil {
+		return nil, err
+	}
+	// 有効期限切れのセッションはエラーとする
+	if c.MaxAge > 0 && p.Time+int64(c.MaxAge) < time.Now().Unix() {
+		return nil, fmt.Errorf("session cookie expired")
+	}
+	return &p, nil
+}
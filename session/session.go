@@ -0,0 +1,100 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Session : クッキーに保存するセッション情報を管理する構造体
+type Session struct {
+	mu       sync.Mutex
+	data     map[string]interface{} // セッションデータ
+	flashes  map[string]interface{} // 前回のリクエストで登録された一度きりのデータ
+	next     map[string]interface{} // 次回のリクエストへ渡す一度きりのデータ
+	modified bool                   // クッキーの更新が必要か否か
+}
+
+// New : 空のセッションを生成する
+func New() *Session {
+	return &Session{
+		data:    make(map[string]interface{}),
+		flashes: make(map[string]interface{}),
+		next:    make(map[string]interface{}),
+	}
+}
+
+// Set : 値をセットする
+func (s *Session) Set(key string, val interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = val
+	s.modified = true
+}
+
+// Get : セットした値を文字列で取得する
+func (s *Session) Get(key string) string {
+	if val := s.Val(key); val != nil {
+		return fmt.Sprint(val)
+	}
+	return ""
+}
+
+// Val : セットした値をinterface{}で取得する。クッキーから復元した数値は float64 となる
+func (s *Session) Val(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data[key]
+}
+
+// Delete : セットした値を削除する
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[key]; ok {
+		delete(s.data, key)
+		s.modified = true
+	}
+}
+
+// Clear : セットした値、一度きりのデータをすべて削除する
+func (s *Session) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = make(map[string]interface{})
+	s.flashes = make(map[string]interface{})
+	s.next = make(map[string]interface{})
+	s.modified = true
+}
+
+// Flash : 次回のリクエストでのみ取得できる、一度きりのデータをセットする
+func (s *Session) Flash(key string, val interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next[key] = val
+	s.modified = true
+}
+
+// GetFlash : 前回のリクエストでセットされた、一度きりのデータを取得する。データはリクエスト終了時に破棄される
+func (s *Session) GetFlash(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flashes[key]
+}
+
+// Flashes : 前回のリクエストでセットされた、一度きりのデータをすべて複製して返却する
+func (s *Session) Flashes() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result = make(map[string]interface{}, len(s.flashes))
+	for key, val := range s.flashes {
+		result[key] = val
+	}
+	return result
+}
+
+// Modified : クッキーの更新が必要な場合は true を返却する
+func (s *Session) Modified() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.modified
+}
@@ -0,0 +1,194 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// レスポンスに出力されたクッキーを、次のリクエストへ付与する
+func next(w *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+// 署名鍵が短い場合のチェック
+func Test_InitError(t *testing.T) {
+	c := &Config{Keys: [][]byte{[]byte("short")}}
+	if err := c.Init(); err == nil {
+		t.Fatal("ERROR")
+	}
+}
+
+// セッションの保存と復元のチェック
+func Test_Session(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		c := &Config{
+			Path:    "/baseurl",
+			Secure:  true,
+			MaxAge:  3600,
+			Encrypt: encrypt,
+		}
+		if err := c.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		// 値をセットし、クッキーへ保存する
+		s := c.Load(httptest.NewRequest("GET", "/", nil))
+		if s.Modified() {
+			t.Fatal("ERROR")
+		}
+		s.Set("user", "name")
+		s.Set("id", 10)
+		w := httptest.NewRecorder()
+		if err := c.Save(w, s); err != nil {
+			t.Fatal(err)
+		}
+		header := w.Header().Get("Set-Cookie")
+		if strings.Index(header, "session=") != 0 || strings.Index(header, "Path=/baseurl") == -1 ||
+			strings.Index(header, "HttpOnly") == -1 || strings.Index(header, "Secure") == -1 ||
+			strings.Index(header, "SameSite=Lax") == -1 {
+			t.Fatal(header)
+		}
+		// 暗号化指定時は、クッキーから値を読み取れない
+		if encrypt && strings.Index(header, "bmFtZ") != -1 {
+			t.Fatal(header)
+		}
+
+		// クッキーから値を復元する
+		s = c.Load(next(w))
+		if s.Get("user") != "name" || s.Get("id") != "10" || s.Val("undefined") != nil {
+			t.Fatal("ERROR")
+		}
+		// 変更がない場合は、クッキーを出力しない
+		w = httptest.NewRecorder()
+		c.Save(w, s)
+		if w.Header().Get("Set-Cookie") != "" {
+			t.Fatal("ERROR")
+		}
+
+		// 値を削除する
+		s.Delete("user")
+		if s.Get("user") != "" || s.Modified() == false {
+			t.Fatal("ERROR")
+		}
+		// すべての値を削除した場合は、クッキーを削除する
+		s.Clear()
+		w = httptest.NewRecorder()
+		c.Save(w, s)
+		if strings.Index(w.Header().Get("Set-Cookie"), "Max-Age=0") == -1 {
+			t.Fatal(w.Header().Get("Set-Cookie"))
+		}
+	}
+}
+
+// 改ざんされたクッキーのチェック
+func Test_Tamper(t *testing.T) {
+	c := &Config{}
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s := New()
+	s.Set("role", "user")
+	w := httptest.NewRecorder()
+	c.Save(w, s)
+	cookie := w.Result().Cookies()[0]
+
+	// 値を改ざんした場合は、空のセッションとなり、クッキーは削除される
+	for _, value := range []string{"invalid", "e30." + strings.Split(cookie.Value, ".")[1], cookie.Value + "A"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: "session", Value: value})
+		s := c.Load(r)
+		if s.Get("role") != "" || s.Modified() == false {
+			t.Fatal(value)
+		}
+	}
+
+	// 別の鍵で署名されたクッキーは検証に失敗する
+	other := &Config{}
+	other.Init()
+	if other.Load(next(w)).Get("role") != "" {
+		t.Fatal("ERROR")
+	}
+}
+
+// 署名鍵のローテーションのチェック
+func Test_Rotation(t *testing.T) {
+	oldkey := []byte("0123456789abcdef0123456789abcdef")
+	newkey := []byte("fedcba9876543210fedcba9876543210")
+
+	// 古い鍵で署名する
+	old := &Config{Keys: [][]byte{oldkey}, Encrypt: true}
+	if err := old.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s := New()
+	s.Set("key", "value")
+	w := httptest.NewRecorder()
+	old.Save(w, s)
+
+	// 新しい鍵を先頭に追加した場合、古い鍵で署名されたクッキーも検証できる
+	c := &Config{Keys: [][]byte{newkey, oldkey}, Encrypt: true}
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s = c.Load(next(w))
+	if s.Get("key") != "value" {
+		t.Fatal("ERROR")
+	}
+	// 再保存したクッキーは新しい鍵で署名され、古い鍵では検証できない
+	s.Set("key", "value2")
+	w = httptest.NewRecorder()
+	c.Save(w, s)
+	if old.Load(next(w)).Get("key") != "" || c.Load(next(w)).Get("key") != "value2" {
+		t.Fatal("ERROR")
+	}
+}
+
+// 一度きりのデータのチェック
+func Test_Flash(t *testing.T) {
+	c := &Config{}
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 1回目のリクエストでセットした一度きりのデータは、同じリクエスト内では取得できない
+	s := c.Load(httptest.NewRequest("GET", "/", nil))
+	s.Flash("notice", "saved")
+	if s.GetFlash("notice") != nil {
+		t.Fatal("ERROR")
+	}
+	w := httptest.NewRecorder()
+	c.Save(w, s)
+
+	// 2回目のリクエストで取得できる
+	s = c.Load(next(w))
+	if s.GetFlash("notice") != "saved" || s.Flashes()["notice"] != "saved" {
+		t.Fatal("ERROR")
+	}
+	w = httptest.NewRecorder()
+	c.Save(w, s)
+
+	// 3回目のリクエストでは破棄されている
+	s = c.Load(next(w))
+	if s.GetFlash("notice") != nil || len(s.Flashes()) != 0 {
+		t.Fatal("ERROR")
+	}
+}
+
+// クッキーの最大長のチェック
+func Test_CookieSize(t *testing.T) {
+	c := &Config{}
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s := New()
+	s.Set("large", strings.Repeat("a", 5000))
+	if err := c.Save(httptest.NewRecorder(), s); err == nil {
+		t.Fatal("ERROR")
+	}
+}
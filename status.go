@@ -80,6 +80,7 @@ func (err *InvalidReturn) Error() string {
 // MarshalError : json/xml 等の解析エラー
 type MarshalError struct {
 	Message string
+	Path    string // 変換に失敗したデータの位置(ex: users[0].name)
 }
 
 func (err *MarshalError) Error() string {
@@ -115,6 +116,29 @@ func (err *AccessDenied) Error() string {
 	return err.Message
 }
 
+// CSRFError : CSRFトークンの検証に失敗した場合のエラー
+type CSRFError struct {
+	Message    string
+	StatusCode int
+	Method     string
+}
+
+func (err *CSRFError) Error() string {
+	return err.Message
+}
+
+// NotAcceptable : Accept ヘッダ、またはクエリパスの拡張子に対応する形式で出力できない場合のエラー
+type NotAcceptable struct {
+	Message    string
+	StatusCode int
+	Accept     string
+	Formats    []string
+}
+
+func (err *NotAcceptable) Error() string {
+	return err.Message
+}
+
 // Unauthorized : 401 Unauthorized エラー
 type Unauthorized struct {
 	Message    string
@@ -0,0 +1,149 @@
+package mux
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/ochipin/mux/basemux"
+)
+
+// Stream : レスポンスボディを逐次書き込む構造体
+type Stream struct {
+	content    string                // Content-Type
+	statuscode int                   // ステータスコード
+	header     http.Header           // 追加で出力するヘッダ
+	fn         func(io.Writer) error // レスポンスボディを書き込む関数
+}
+
+// Code : ステータスコードを指定する(デフォルトは200)
+func (s *Stream) Code(status int) *Stream {
+	s.statuscode = status
+	return s
+}
+
+// Header : 追加で出力するヘッダを指定する
+func (s *Stream) Header(key, value string) *Stream {
+	s.header.Set(key, value)
+	return s
+}
+
+// basemux.StreamView へ変換する
+func (s *Stream) view() *basemux.StreamView {
+	return &basemux.StreamView{
+		StatusCode:  s.statuscode,
+		ContentType: s.content,
+		Header:      s.header,
+		Body:        s.fn,
+	}
+}
+
+func (s *Stream) pointer() {}
+
+// SendFile : io.Reader から読み込んだ内容を、ファイルとして送信する構造体
+type SendFile struct {
+	name    string    // ファイル名
+	reader  io.Reader // 送信する内容
+	content string    // Content-Type
+	size    int64     // Content-Length
+	inline  bool      // ブラウザ内で表示するか否か
+}
+
+// Type : Content-Type を指定する。未指定の場合は、ファイル名の拡張子から判定する
+func (f *SendFile) Type(content string) *SendFile {
+	f.content = content
+	return f
+}
+
+// Size : Content-Length を指定する。*os.File、Len 関数を持つ io.Reader の場合は自動で設定される
+func (f *SendFile) Size(size int64) *SendFile {
+	f.size = size
+	return f
+}
+
+// Inline : ダウンロードせず、ブラウザ内で表示する
+func (f *SendFile) Inline() *SendFile {
+	f.inline = true
+	return f
+}
+
+// basemux.StreamView へ変換する
+func (f *SendFile) view() *basemux.StreamView {
+	disposition := "attachment"
+	if f.inline {
+		disposition = "inline"
+	}
+	header := make(http.Header)
+	if v := mime.FormatMediaType(disposition, map[string]string{"filename": f.name}); v != "" {
+		header.Set("Content-Disposition", v)
+	} else {
+		header.Set("Content-Disposition", disposition)
+	}
+
+	return &basemux.StreamView{
+		StatusCode:    200,
+		ContentType:   f.content,
+		ContentLength: f.size,
+		Header:        header,
+		Body: func(w io.Writer) error {
+			// 送信後、io.Closer の場合はクローズする
+			if closer, ok := f.reader.(io.Closer); ok {
+				defer closer.Close()
+			}
+			_, err := io.Copy(w, f.reader)
+			return err
+		},
+	}
+}
+
+func (f *SendFile) pointer() {}
+
+// io.Reader から、送信するサイズを判定する。判定できない場合は 0 を返却する
+func readerSize(reader io.Reader) int64 {
+	switch types := reader.(type) {
+	case *os.File:
+		if info, err := types.Stat(); err == nil && info.Mode().IsRegular() {
+			return info.Size()
+		}
+	case interface{ Len() int }:
+		return int64(types.Len())
+	}
+	return 0
+}
+
+// Event : basemux.Event のエイリアス
+type Event = basemux.Event
+
+// EventWriter : basemux.EventWriter のエイリアス
+type EventWriter = basemux.EventWriter
+
+// EventStream : Server-Sent Events を送信する構造体
+type EventStream struct {
+	retry  int                      // 再接続までの待ち時間(ミリ秒単位)
+	header http.Header              // 追加で出力するヘッダ
+	fn     func(*EventWriter) error // イベントを送信する関数
+}
+
+// Retry : クライアントが再接続するまでの待ち時間(ミリ秒単位)を指定する
+func (s *EventStream) Retry(ms int) *EventStream {
+	s.retry = ms
+	return s
+}
+
+// Header : 追加で出力するヘッダを指定する
+func (s *EventStream) Header(key, value string) *EventStream {
+	s.header.Set(key, value)
+	return s
+}
+
+// basemux.EventStreamView へ変換する
+func (s *EventStream) view() *basemux.EventStreamView {
+	return &basemux.EventStreamView{
+		Header: s.header,
+		Retry:  s.retry,
+		Body:   s.fn,
+	}
+}
+
+func (s *EventStream) pointer() {}
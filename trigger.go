@@ -17,6 +17,11 @@ type Trigger interface {
 	ErrorReport(err error, code int, status string)
 }
 
+// ProblemTrigger : エラーを RFC 7807 形式で出力する際にコールされるトリガ。Trigger に追加で実装する
+type ProblemTrigger interface {
+	CustomProblem(*Problem, *ErrorStatus)
+}
+
 // BaseTrigger : Mux構造体に登録するベースとなるトリガ。実装は空となっており、何もしない。
 type BaseTrigger struct{}
 
@@ -35,5 +40,8 @@ func (t BaseTrigger) SetHelper(helpers *helpers.Helpers) interface{} { return he
 // CustomError : 2xx 以外のエラー発生時にコールされるトリガ
 func (t BaseTrigger) CustomError(status *ErrorStatus, err error, execname string) bool { return false }
 
+// CustomProblem : エラーを RFC 7807 形式(JSON/XML)で出力する際にコールされるトリガ。項目の変更、追加ができる
+func (t BaseTrigger) CustomProblem(problem *Problem, status *ErrorStatus) {}
+
 // ErrorReport : 2xx 以外のエラー発生時にコールされるトリガ
 func (t BaseTrigger) ErrorReport(err error, code int, status string) {}
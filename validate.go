@@ -0,0 +1,197 @@
+package mux
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+
+	"github.com/ochipin/router"
+)
+
+// RouteError : アクションの定義に誤りがあるルートのエラー
+type RouteError struct {
+	Method     string // メソッド名
+	Path       string // クエリパスのパターン
+	Name       string // "コントローラ名.アクション名"
+	StatusName string // エラーの種類(ex: IllegalArguments, NotEnoughReturn, NoMixIn)
+	Message    string
+}
+
+func (err *RouteError) Error() string {
+	return fmt.Sprintf("'%s %s' (%s): %s: %s", err.Method, err.Path, err.Name, err.StatusName, err.Message)
+}
+
+// RoutesError : アクションの定義に誤りがあるルートの一覧
+type RoutesError []*RouteError
+
+func (err RoutesError) Error() string {
+	var messages = make([]string, len(err))
+	for i, e := range err {
+		messages[i] = e.Error()
+	}
+	return fmt.Sprintf("%d invalid routes:\n%s", len(err), strings.Join(messages, "\n"))
+}
+
+// ValidateRoutes : ルーティングテーブルに登録されたすべてのルートについて、アクションの定義を検証する
+// ルートのパターンから生成したクエリパスでアクションを取得し、実行時と同じ router.SetStruct、Valid で検証する
+// 誤りがあるルートは、すべて RoutesError として返却する
+// 生成したクエリパスでアクションを取得できないルートは、検証できないためエラーとせず、CheckRoutes で警告する
+func (mux *Mux) ValidateRoutes() error {
+	if mux.Router == nil {
+		return fmt.Errorf("routing table is not created")
+	}
+	if result, _ := mux.checkActions(); result != nil {
+		return result
+	}
+	return nil
+}
+
+// すべてのルートのアクションの定義を検証し、誤りがあるルートと、アクションを取得できなかったルートを返却する
+func (mux *Mux) checkActions() (result, notfound RoutesError) {
+	for _, r := range mux.routeList() {
+		if err := mux.checkAction(r); err == nil {
+			continue
+		} else if err.StatusName == "NotRoutes" {
+			notfound = append(notfound, err)
+		} else {
+			result = append(result, err)
+		}
+	}
+	return result, notfound
+}
+
+// ルートのアクションの定義を検証する
+func (mux *Mux) checkAction(r *route) *RouteError {
+	fail := func(err error) *RouteError {
+		return &RouteError{Method: r.method, Path: r.path, Name: r.name, StatusName: routeErrorName(err), Message: err.Error()}
+	}
+
+	res, args, err := mux.caller(r.method, mux.samplePath(r))
+	if err != nil {
+		return fail(err)
+	}
+	// 同じクエリパスに該当する別のルートが取得された場合は、overlaps で警告するため検証しない
+	if ctlname, actname := res.Name(); ctlname+"."+actname != r.name {
+		return nil
+	}
+	action, err := res.Get()
+	if err != nil {
+		return fail(err)
+	}
+	if err := router.SetStruct(action, &Controller{}); err != nil {
+		return fail(err)
+	}
+	if _, err := res.Valid(action, args, "mux.Result"); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+// ルートのパターンから、ルートに該当するクエリパスを生成する
+// パラメータは、登録された正規表現に一致する短い文字列に置き換える。正規表現が未登録、または一致する文字列を生成できない場合は "0" とする
+func (mux *Mux) samplePath(r *route) string {
+	segments := strings.Split(r.path, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+		segments[i] = "0"
+		re, ok := mux.paramRegexp(segment[1:])
+		if !ok {
+			continue
+		}
+		parsed, err := syntax.Parse(re, syntax.Perl)
+		if err != nil {
+			continue
+		}
+		sample := sampleString(parsed.Simplify())
+		if matched, _ := regexp.MatchString(`^(?:`+re+`)$`, sample); matched && sample != "" {
+			segments[i] = sample
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// 正規表現に一致する短い文字列を生成する。空文字列に一致する繰り返しも、パラメータが空とならないよう一度繰り返す
+func sampleString(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return string(re.Rune)
+	case syntax.OpCharClass:
+		return sampleRune(re.Rune)
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return "0"
+	case syntax.OpCapture, syntax.OpPlus, syntax.OpStar, syntax.OpQuest:
+		return sampleString(re.Sub[0])
+	case syntax.OpRepeat:
+		count := re.Min
+		if count == 0 && re.Max != 0 {
+			count = 1
+		}
+		return strings.Repeat(sampleString(re.Sub[0]), count)
+	case syntax.OpAlternate:
+		return sampleString(re.Sub[0])
+	case syntax.OpConcat:
+		var result string
+		for _, sub := range re.Sub {
+			result += sampleString(sub)
+		}
+		return result
+	}
+	// 行頭、行末、単語境界などの幅を持たない条件は、空文字列とする
+	return ""
+}
+
+// 文字クラスの範囲(開始、終了の組)に含まれる文字を返却する
+// 英数字を優先し、該当しない場合は、制御文字、空白、"/" 以外の最初の文字とする
+func sampleRune(ranges []rune) string {
+	contains := func(c rune) bool {
+		for i := 0; i+1 < len(ranges); i += 2 {
+			if ranges[i] <= c && c <= ranges[i+1] {
+				return true
+			}
+		}
+		return false
+	}
+	for _, c := range "0aA_-" {
+		if contains(c) {
+			return string(c)
+		}
+	}
+	for i := 0; i+1 < len(ranges); i += 2 {
+		for c := ranges[i]; c <= ranges[i+1] && c < ranges[i]+0x80; c++ {
+			if unicode.IsPrint(c) && c != ' ' && c != '/' {
+				return string(c)
+			}
+		}
+	}
+	if len(ranges) > 0 {
+		return string(ranges[0])
+	}
+	return ""
+}
+
+// ルーティングテーブルが返却したエラーから、エラーの種類を返却する
+func routeErrorName(err error) string {
+	switch err.(type) {
+	case *router.NotRoutes:
+		return "NotRoutes"
+	case *router.IllegalArgs:
+		return "IllegalArguments"
+	case *router.NotEnoughArgs:
+		return "NotEnoughArguments"
+	case *router.IllegalRets:
+		return "IllegalReturn"
+	case *router.NotEnoughRets:
+		return "NotEnoughReturn"
+	case *router.InvalidError:
+		return "MixInInvalid"
+	case *router.NoStruct:
+		return "MixInNoStructType"
+	case *router.NoMixin:
+		return "NoMixIn"
+	}
+	return "UnknownError"
+}
@@ -0,0 +1,56 @@
+package mux
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/ochipin/mux/basemux"
+	"github.com/ochipin/mux/websocket"
+)
+
+// WebSocket : WebSocket で通信する構造体
+type WebSocket struct {
+	upgrader websocket.Upgrader          // ハンドシェイクの設定
+	fn       func(*websocket.Conn) error // 接続後に通信を行う関数
+}
+
+// Origins : 接続を許可するオリジンを指定する。"*" の場合はすべて許可する
+func (ws *WebSocket) Origins(origins ...string) *WebSocket {
+	ws.upgrader.Origins = origins
+	return ws
+}
+
+// Subprotocols : サポートするサブプロトコルを指定する
+func (ws *WebSocket) Subprotocols(protocols ...string) *WebSocket {
+	ws.upgrader.Subprotocols = protocols
+	return ws
+}
+
+// Render : WebSocket で通信する。エラーが発生した場合は、ログへ出力する
+func (ws *WebSocket) Render(w http.ResponseWriter, r *http.Request) {
+	if err := ws.Stream(w, r); err != nil {
+		log.Println(err)
+	}
+}
+
+// Stream : ハンドシェイクを行い、WebSocket で通信する。通信中はタイムアウトを無効にする
+// ハンドシェイクに失敗した場合は、websocket.HandshakeError を返却する
+func (ws *WebSocket) Stream(w http.ResponseWriter, r *http.Request) error {
+	basemux.DisableTimeout(r)
+	conn, err := ws.upgrader.Upgrade(w, r)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// 正常な切断の場合は、エラーとしない
+	if err := ws.fn(conn); err != nil {
+		if closeErr, ok := err.(*websocket.CloseError); ok && closeErr.Normal() {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (ws *WebSocket) pointer() {}
@@ -0,0 +1,326 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// メッセージの種類
+const (
+	TextMessage   = 1  // テキストメッセージ
+	BinaryMessage = 2  // バイナリメッセージ
+	CloseMessage  = 8  // 切断
+	PingMessage   = 9  // ping
+	PongMessage   = 10 // pong
+)
+
+// 切断時のステータスコード
+const (
+	CloseNormalClosure   = 1000 // 正常終了
+	CloseGoingAway       = 1001 // サーバの停止、またはページの移動
+	CloseProtocolError   = 1002 // プロトコルエラー
+	CloseUnsupportedData = 1003 // 受信できないデータ
+	CloseNoStatus        = 1005 // ステータスコードなし
+	CloseInvalidPayload  = 1007 // 不正なデータ(UTF-8 ではないテキストなど)
+	CloseMessageTooBig   = 1009 // メッセージサイズの超過
+)
+
+// 継続フレームのオペコード
+const continuation = 0
+
+// 制御フレームの書き込みタイムアウト
+const writeWait = 10 * time.Second
+
+// CloseError : 接続が切断された場合のエラー
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (err *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", err.Code, err.Text)
+}
+
+// Normal : 正常な切断か否かを返却する
+func (err *CloseError) Normal() bool {
+	switch err.Code {
+	case CloseNormalClosure, CloseGoingAway, CloseNoStatus:
+		return true
+	}
+	return false
+}
+
+// Conn : WebSocket 接続
+type Conn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	subprotocol string
+	maxSize     int64
+	pongWait    time.Duration
+	wmu         sync.Mutex    // 書き込みを保護するミューテックス
+	closeSent   bool          // 切断フレームを送信済みか否か
+	closed      chan struct{} // Close のコール時にクローズするチャネル
+	once        sync.Once
+}
+
+// Conn を生成する
+func newConn(conn net.Conn, reader *bufio.Reader, subprotocol string, maxSize int64, pongWait time.Duration) *Conn {
+	c := &Conn{
+		conn:        conn,
+		reader:      reader,
+		subprotocol: subprotocol,
+		maxSize:     maxSize,
+		pongWait:    pongWait,
+		closed:      make(chan struct{}),
+	}
+	c.extendDeadline()
+	return c
+}
+
+// 受信のタイムアウト時刻を延長する
+func (c *Conn) extendDeadline() {
+	if c.pongWait > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.pongWait))
+	}
+}
+
+// 一定間隔で ping を送信する。ctx が終了した場合は、接続をクローズする
+func (c *Conn) keepalive(ctx context.Context, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-tick:
+			if err := c.WriteMessage(PingMessage, nil); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-ctx.Done():
+			c.CloseWith(CloseGoingAway, "server shutting down")
+			return
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// Subprotocol : ハンドシェイク時に選択したサブプロトコルを返却する
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr : クライアントのアドレスを返却する
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage : メッセージを受信する。ping、pong、切断の制御フレームは、この関数内で処理する
+// 切断フレームを受信した場合は CloseError を返却する
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var msgtype int
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		c.extendDeadline()
+
+		switch opcode {
+		// ping を受信した場合は、pong を返信する
+		case PingMessage:
+			if err := c.WriteMessage(PongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		// 切断フレームを受信した場合は、同じステータスコードで切断フレームを返信する
+		case CloseMessage:
+			closeErr := &CloseError{Code: CloseNoStatus}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Text = string(payload[2:])
+			}
+			c.CloseWith(closeErr.Code, "")
+			return 0, nil, closeErr
+		case TextMessage, BinaryMessage:
+			if msgtype != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected data frame")
+			}
+			msgtype = opcode
+		case continuation:
+			if msgtype == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+		}
+
+		// 最大サイズを超過した場合は切断する
+		if int64(len(message)+len(payload)) > c.maxSize {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		message = append(message, payload...)
+		if fin == false {
+			continue
+		}
+		if msgtype == TextMessage && utf8.Valid(message) == false {
+			return 0, nil, c.fail(CloseInvalidPayload, "invalid utf-8 text")
+		}
+		if message == nil {
+			message = []byte{}
+		}
+		return msgtype, message, nil
+	}
+}
+
+// フレームを1つ読み込む
+func (c *Conn) readFrame() (bool, int, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := int(head[0] & 0x0f)
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	// クライアントからのフレームは、マスクされていなければならない
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "frame not masked")
+	}
+
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var buf [2]byte
+		if _, err := io.ReadFull(c.reader, buf[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(buf[:]))
+	case 127:
+		var buf [8]byte
+		if _, err := io.ReadFull(c.reader, buf[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(buf[:]))
+	}
+	// 制御フレームは、分割されず、125バイト以下でなければならない
+	if opcode >= CloseMessage && (fin == false || length > 125) {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if length < 0 || length > c.maxSize {
+		return false, 0, nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// プロトコルエラーなどが発生した場合、切断フレームを送信し、エラーを返却する
+func (c *Conn) fail(code int, text string) error {
+	c.CloseWith(code, text)
+	return &CloseError{Code: code, Text: text}
+}
+
+// WriteMessage : メッセージを送信する。複数の goroutine から同時にコールできる
+func (c *Conn) WriteMessage(msgtype int, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closeSent {
+		return &CloseError{Code: CloseNormalClosure, Text: "connection closed"}
+	}
+	if msgtype == CloseMessage {
+		c.closeSent = true
+	}
+	return c.writeFrame(msgtype, data)
+}
+
+// フレームを書き込む。サーバからのフレームはマスクしない
+func (c *Conn) writeFrame(opcode int, data []byte) error {
+	var buf = []byte{0x80 | byte(opcode)}
+	switch length := len(data); {
+	case length <= 125:
+		buf = append(buf, byte(length))
+	case length <= 0xffff:
+		buf = append(buf, 126, 0, 0)
+		binary.BigEndian.PutUint16(buf[2:], uint16(length))
+	default:
+		buf = append(buf, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[2:], uint64(length))
+	}
+	buf = append(buf, data...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// ReadJSON : テキストメッセージを受信し、JSON として v へ変換する
+func (c *Conn) ReadJSON(v interface{}) error {
+	_, message, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(message, v)
+}
+
+// WriteJSON : v を JSON へ変換し、テキストメッセージとして送信する
+func (c *Conn) WriteJSON(v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(TextMessage, buf)
+}
+
+// Close : 正常終了の切断フレームを送信し、接続をクローズする
+func (c *Conn) Close() error {
+	return c.CloseWith(CloseNormalClosure, "")
+}
+
+// CloseWith : 指定したステータスコードで切断フレームを送信し、接続をクローズする
+func (c *Conn) CloseWith(code int, text string) error {
+	var err error
+	c.once.Do(func() {
+		close(c.closed)
+		// ステータスコードなしの場合は、空の切断フレームを送信する
+		var payload []byte
+		if code != CloseNoStatus {
+			// 制御フレームは125バイト以下のため、理由は123バイトまでとする
+			if len(text) > 123 {
+				text = text[:123]
+			}
+			payload = make([]byte, 2, 2+len(text))
+			binary.BigEndian.PutUint16(payload, uint16(code))
+			payload = append(payload, text...)
+		}
+		c.WriteMessage(CloseMessage, payload)
+		err = c.conn.Close()
+	})
+	return err
+}
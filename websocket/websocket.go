@@ -0,0 +1,171 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Sec-WebSocket-Accept の生成に使用する GUID
+const guid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// HandshakeError : ハンドシェイクに失敗した場合のエラー
+type HandshakeError struct {
+	Message    string
+	StatusCode int
+}
+
+func (err *HandshakeError) Error() string {
+	return err.Message
+}
+
+// Upgrader : HTTP 接続を WebSocket 接続へ切り替える設定
+type Upgrader struct {
+	Origins        []string // 接続を許可するオリジン(ex: https://example.com)。"*" の場合はすべて許可する。未設定の場合は同一オリジンのみ許可する
+	Subprotocols   []string // サポートするサブプロトコル
+	PingInterval   int      // ping を送信する間隔(秒単位)。未設定の場合は30秒。0未満の場合は送信しない
+	PongWait       int      // 受信がない場合に切断するまでの時間(秒単位)。未設定の場合は PingInterval の2倍
+	MaxMessageSize int64    // 受信するメッセージの最大サイズ(バイト単位)。未設定の場合は1MB
+}
+
+// 設定値から ping を送信する間隔を返却する
+func (u *Upgrader) pingInterval() time.Duration {
+	switch {
+	case u.PingInterval < 0:
+		return 0
+	case u.PingInterval == 0:
+		return 30 * time.Second
+	}
+	return time.Duration(u.PingInterval) * time.Second
+}
+
+// 設定値から、受信がない場合に切断するまでの時間を返却する
+func (u *Upgrader) pongWait() time.Duration {
+	if u.PongWait > 0 {
+		return time.Duration(u.PongWait) * time.Second
+	}
+	return u.pingInterval() * 2
+}
+
+// 設定値から、受信するメッセージの最大サイズを返却する
+func (u *Upgrader) maxMessageSize() int64 {
+	if u.MaxMessageSize <= 0 {
+		return 1 << 20
+	}
+	return u.MaxMessageSize
+}
+
+// 接続を許可するオリジンか否かを判定する
+func (u *Upgrader) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	// ブラウザ以外のクライアントは、Origin ヘッダを送信しない
+	if origin == "" {
+		return true
+	}
+	// 未設定の場合は、同一オリジンのみ許可する
+	if len(u.Origins) == 0 {
+		uri, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(uri.Host, r.Host)
+	}
+	for _, v := range u.Origins {
+		if v == "*" || strings.EqualFold(strings.TrimRight(v, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// クライアントが要求したサブプロトコルのうち、サポートしているサブプロトコルを返却する
+func (u *Upgrader) subprotocol(r *http.Request) string {
+	for _, v := range strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",") {
+		v = strings.TrimSpace(v)
+		for _, protocol := range u.Subprotocols {
+			if v == protocol {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// ヘッダに、指定したトークンが含まれているか判定する
+func headerContains(header http.Header, name, token string) bool {
+	for _, v := range header[name] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade : ハンドシェイクを行い、WebSocket 接続を返却する
+// ハンドシェイクに失敗した場合は、レスポンスを書き込まずに HandshakeError を返却する
+// w の Header に設定されているヘッダ(Set-Cookie など)は、ハンドシェイクのレスポンスに付与される
+// r のコンテキストが終了した場合は、接続をクローズする
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	// WebSocket のハンドシェイク要求か確認する
+	if r.Method != "GET" {
+		return nil, &HandshakeError{"websocket: method not GET", 405}
+	}
+	if headerContains(r.Header, "Connection", "upgrade") == false || headerContains(r.Header, "Upgrade", "websocket") == false {
+		return nil, &HandshakeError{"websocket: not a websocket handshake", 400}
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, &HandshakeError{"websocket: unsupported version", 400}
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if buf, err := base64.StdEncoding.DecodeString(key); err != nil || len(buf) != 16 {
+		return nil, &HandshakeError{"websocket: invalid Sec-WebSocket-Key", 400}
+	}
+	if u.checkOrigin(r) == false {
+		return nil, &HandshakeError{"websocket: origin not allowed: " + r.Header.Get("Origin"), 403}
+	}
+
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, &HandshakeError{"websocket: response does not implement http.Hijacker", 500}
+	}
+	subprotocol := u.subprotocol(r)
+	header := w.Header()
+	netconn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	// ハンドシェイクのレスポンスを書き込む
+	hash := sha1.New()
+	hash.Write([]byte(key + guid))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	brw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash.Sum(nil)) + "\r\n")
+	if subprotocol != "" {
+		brw.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	for name, values := range header {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Type", "Content-Length", "Sec-Websocket-Version":
+			continue
+		}
+		for _, v := range values {
+			brw.WriteString(fmt.Sprintf("%s: %s\r\n", name, strings.NewReplacer("\r", "", "\n", "").Replace(v)))
+		}
+	}
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		netconn.Close()
+		return nil, err
+	}
+
+	c := newConn(netconn, brw.Reader, subprotocol, u.maxMessageSize(), u.pongWait())
+	go c.keepalive(r.Context(), u.pingInterval())
+	return c, nil
+}
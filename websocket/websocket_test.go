@@ -0,0 +1,233 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// テスト用の WebSocket クライアント
+type client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// ハンドシェイクを行い、レスポンスを返却する
+func dial(t *testing.T, url string, header http.Header) (*client, *http.Response) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", url+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Write(conn)
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &client{conn, reader}, res
+}
+
+// マスクしたフレームを送信する
+func (c *client) write(fin bool, opcode int, data []byte) {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	buf := []byte{b0}
+	switch {
+	case len(data) <= 125:
+		buf = append(buf, 0x80|byte(len(data)))
+	default:
+		buf = append(buf, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(buf[2:], uint16(len(data)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	buf = append(buf, mask...)
+	for i, b := range data {
+		buf = append(buf, b^mask[i%4])
+	}
+	c.conn.Write(buf)
+}
+
+// フレームを受信する
+func (c *client) read() (int, []byte) {
+	var head [2]byte
+	if _, err := c.reader.Read(head[:1]); err != nil {
+		return -1, nil
+	}
+	c.reader.Read(head[1:])
+	length := int(head[1] & 0x7f)
+	if length == 126 {
+		var buf [2]byte
+		c.reader.Read(buf[:])
+		length = int(binary.BigEndian.Uint16(buf[:]))
+	}
+	payload := make([]byte, length)
+	for n := 0; n < length; {
+		m, _ := c.reader.Read(payload[n:])
+		n += m
+	}
+	return int(head[0] & 0x0f), payload
+}
+
+// エコーサーバを起動する
+func server(t *testing.T, u *Upgrader) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=value")
+		conn, err := u.Upgrade(w, r)
+		if err != nil {
+			w.WriteHeader(err.(*HandshakeError).StatusCode)
+			return
+		}
+		defer conn.Close()
+		for {
+			msgtype, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(msgtype, message)
+		}
+	}))
+}
+
+// ハンドシェイクのチェック
+func Test_Handshake(t *testing.T) {
+	ts := server(t, &Upgrader{Subprotocols: []string{"chat"}})
+	defer ts.Close()
+
+	c, res := dial(t, ts.URL, http.Header{"Sec-Websocket-Protocol": {"superchat, chat"}})
+	defer c.conn.Close()
+	if res.StatusCode != 101 || res.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" ||
+		res.Header.Get("Sec-WebSocket-Protocol") != "chat" || res.Header.Get("Set-Cookie") != "session=value" {
+		t.Fatal(res.StatusCode, res.Header)
+	}
+
+	// WebSocket のハンドシェイク要求ではない場合はエラー
+	res, err := http.Get(ts.URL + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 400 {
+		t.Fatal(res.StatusCode)
+	}
+}
+
+// オリジンのチェック
+func Test_Origin(t *testing.T) {
+	ts := server(t, &Upgrader{})
+	defer ts.Close()
+
+	// 同一オリジンは許可する
+	c, res := dial(t, ts.URL, http.Header{"Origin": {ts.URL}})
+	c.conn.Close()
+	if res.StatusCode != 101 {
+		t.Fatal(res.StatusCode)
+	}
+	// 異なるオリジンは拒否する
+	c, res = dial(t, ts.URL, http.Header{"Origin": {"http://evil.example.com"}})
+	c.conn.Close()
+	if res.StatusCode != 403 {
+		t.Fatal(res.StatusCode)
+	}
+
+	// 許可したオリジンのみ接続できる
+	ts2 := server(t, &Upgrader{Origins: []string{"https://example.com/"}})
+	defer ts2.Close()
+	c, res = dial(t, ts2.URL, http.Header{"Origin": {"https://example.com"}})
+	c.conn.Close()
+	if res.StatusCode != 101 {
+		t.Fatal(res.StatusCode)
+	}
+	c, res = dial(t, ts2.URL, http.Header{"Origin": {ts2.URL}})
+	c.conn.Close()
+	if res.StatusCode != 403 {
+		t.Fatal(res.StatusCode)
+	}
+}
+
+// メッセージの送受信のチェック
+func Test_Message(t *testing.T) {
+	ts := server(t, &Upgrader{MaxMessageSize: 1000})
+	defer ts.Close()
+	c, _ := dial(t, ts.URL, nil)
+	defer c.conn.Close()
+
+	// テキストメッセージ
+	c.write(true, TextMessage, []byte("hello"))
+	if opcode, data := c.read(); opcode != TextMessage || string(data) != "hello" {
+		t.Fatal(opcode, string(data))
+	}
+	// 分割されたメッセージの間に、ping を送信する
+	c.write(false, BinaryMessage, []byte("abc"))
+	c.write(true, PingMessage, []byte("ping"))
+	if opcode, data := c.read(); opcode != PongMessage || string(data) != "ping" {
+		t.Fatal(opcode, string(data))
+	}
+	c.write(true, continuation, []byte(strings.Repeat("d", 200)))
+	if opcode, data := c.read(); opcode != BinaryMessage || string(data) != "abc"+strings.Repeat("d", 200) {
+		t.Fatal(opcode, string(data))
+	}
+	// 最大サイズを超過した場合は切断される
+	c.write(true, TextMessage, []byte(strings.Repeat("a", 1001)))
+	if opcode, data := c.read(); opcode != CloseMessage || binary.BigEndian.Uint16(data) != CloseMessageTooBig {
+		t.Fatal(opcode, data)
+	}
+}
+
+// 切断のチェック
+func Test_Close(t *testing.T) {
+	ts := server(t, &Upgrader{})
+	defer ts.Close()
+	c, _ := dial(t, ts.URL, nil)
+	defer c.conn.Close()
+
+	// 切断フレームを送信すると、同じステータスコードの切断フレームが返信される
+	c.write(true, CloseMessage, []byte{0x03, 0xe8})
+	if opcode, data := c.read(); opcode != CloseMessage || binary.BigEndian.Uint16(data) != CloseNormalClosure {
+		t.Fatal(opcode, data)
+	}
+	// 切断後は何も受信できない
+	if opcode, _ := c.read(); opcode != -1 {
+		t.Fatal(opcode)
+	}
+}
+
+// ping による接続維持のチェック
+func Test_Keepalive(t *testing.T) {
+	ts := server(t, &Upgrader{PingInterval: 1, PongWait: 3})
+	defer ts.Close()
+	c, _ := dial(t, ts.URL, nil)
+	defer c.conn.Close()
+
+	// 一定間隔で ping が送信される
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if opcode, _ := c.read(); opcode != PingMessage {
+		t.Fatal(opcode)
+	}
+	c.conn.SetReadDeadline(time.Time{})
+
+	// pong を返信しない場合は、PongWait 経過後に切断される
+	start := time.Now()
+	for {
+		if opcode, _ := c.read(); opcode == -1 {
+			break
+		}
+	}
+	if time.Since(start) < time.Second || time.Since(start) > 5*time.Second {
+		t.Fatal(time.Since(start))
+	}
+}